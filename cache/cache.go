@@ -0,0 +1,198 @@
+// Package cache keeps the last known good config of a provider in an encrypted file,
+// read when AWS is unreachable at startup
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCacheKey     = errors.New("cache key must be 16, 24 or 32 bytes")
+	ErrCacheCorrupt = errors.New("cache file is corrupt or encrypted with another key")
+	// ErrCacheName is returned when the cache file was saved by a provider of another name,
+	// e.g. after the secret ID or the base paths changed
+	ErrCacheName = errors.New("cache file was saved by another provider")
+)
+
+// Entry is the cached value of a provider
+type Entry struct {
+	// Name is the name of the provider that saved the value, e.g. aws-secrets:/app/prod
+	Name  string `json:"name"`
+	Value []byte `json:"value"`
+	// Versions are the secret version IDs or the parameter versions of the value
+	Versions map[string]string `json:"versions"`
+	SavedAt  time.Time         `json:"savedAt"`
+}
+
+// Status is the status of the config of a provider
+type Status struct {
+	// Stale is set when the config was read from the cache, until the watcher refreshes it
+	Stale bool
+	// Err is the error of the fetch the cache was read for
+	Err error
+	// SavedAt and Versions are of the last saved or read entry
+	SavedAt  time.Time
+	Versions map[string]string
+}
+
+// Cache is an AES-GCM encrypted file, safe for concurrent use
+type Cache struct {
+	path string
+	aead cipher.AEAD
+
+	mu     sync.Mutex
+	status Status
+}
+
+// New returns a Cache of the file encrypted with the key, an AES-128, AES-192 or AES-256 key
+func New(path string, key []byte) (*Cache, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.cache.New: %w", ErrCacheKey)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.cache.New: %w", err)
+	}
+
+	return &Cache{path: path, aead: aead}, nil
+}
+
+// Save writes the value of the provider name atomically,
+// the file is replaced by a fully written file or left unchanged
+func (c *Cache) Save(name string, value []byte, versions map[string]string) error {
+	entry := &Entry{
+		Name:     name,
+		Value:    value,
+		Versions: versions,
+		SavedAt:  time.Now(),
+	}
+
+	bs, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("viperaws.cache.Cache.Save: %w", err)
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return fmt.Errorf("viperaws.cache.Cache.Save: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = writeFile(c.path, c.aead.Seal(nonce, nonce, bs, nil))
+	if err != nil {
+		return fmt.Errorf("viperaws.cache.Cache.Save: %s, %w", c.path, err)
+	}
+
+	c.status = Status{
+		SavedAt:  entry.SavedAt,
+		Versions: versions,
+	}
+
+	return nil
+}
+
+// Load reads and decrypts the entry
+func (c *Cache) Load() (*Entry, error) {
+	bs, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.cache.Cache.Load: %w", err)
+	}
+
+	n := c.aead.NonceSize()
+	if len(bs) < n {
+		return nil, fmt.Errorf("viperaws.cache.Cache.Load: %s, %w", c.path, ErrCacheCorrupt)
+	}
+
+	plain, err := c.aead.Open(nil, bs[:n], bs[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.cache.Cache.Load: %s, %w", c.path, ErrCacheCorrupt)
+	}
+
+	entry := &Entry{}
+	err = json.Unmarshal(plain, entry)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.cache.Cache.Load: %s, %w", c.path, ErrCacheCorrupt)
+	}
+
+	return entry, nil
+}
+
+// Fallback returns the cached value of the provider name after a fetch failed with err
+// and marks the status stale, a value saved by another provider name is a miss.
+// The returned error wraps both err and the error reading the cache.
+func (c *Cache) Fallback(name string, err error) ([]byte, error) {
+	entry, lerr := c.Load()
+	if lerr != nil {
+		return nil, fmt.Errorf("%w, %w", err, lerr)
+	}
+
+	if entry.Name != name {
+		return nil, fmt.Errorf("%w, viperaws.cache.Cache.Fallback: %s saved by %q, not %q, %w",
+			err, c.path, entry.Name, name, ErrCacheName)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.status = Status{
+		Stale:    true,
+		Err:      err,
+		SavedAt:  entry.SavedAt,
+		Versions: entry.Versions,
+	}
+
+	return entry.Value, nil
+}
+
+// Status returns the status, fresh until the cache is read by Fallback
+func (c *Cache) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.status
+	s.Versions = maps.Clone(s.Versions)
+
+	return s
+}
+
+// writeFile writes a temporary file in the same directory and renames it, readable by the owner only
+func writeFile(path string, bs []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	tmp := f.Name()
+	defer func() {
+		// No-op once renamed
+		_ = os.Remove(tmp)
+	}()
+
+	_, err = f.Write(bs)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
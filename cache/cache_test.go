@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.cache")
+	key := bytes.Repeat([]byte("k"), 32)
+
+	c, err := New(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Save("aws-secrets:/app/db", []byte(`{"password":"p1"}`), map[string]string{"/app/db": "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("p1")) {
+		t.Error("the cache file is not encrypted")
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("mode: %s, expected -rw-------", fi.Mode().Perm())
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("files: %d, expected no temporary file left", len(entries))
+	}
+
+	if c.Status().Stale {
+		t.Error("stale after Save")
+	}
+
+	fetchErr := errors.New("unreachable")
+	value, err := c.Fallback("aws-secrets:/app/db", fetchErr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != `{"password":"p1"}` {
+		t.Errorf("value: %s", value)
+	}
+
+	s := c.Status()
+	if !s.Stale || !errors.Is(s.Err, fetchErr) || s.Versions["/app/db"] != "v1" || s.SavedAt.IsZero() {
+		t.Errorf("status: %+v", s)
+	}
+
+	// A value saved by another provider is a miss
+	_, err = c.Fallback("aws-secrets:/app/other", fetchErr)
+	if !errors.Is(err, ErrCacheName) || !errors.Is(err, fetchErr) {
+		t.Errorf("error: %v, expected ErrCacheName wrapping the fetch error", err)
+	}
+
+	// Another key cannot decrypt the file
+	other, err := New(path, bytes.Repeat([]byte("o"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = other.Fallback("aws-secrets:/app/db", fetchErr)
+	if !errors.Is(err, ErrCacheCorrupt) || !errors.Is(err, fetchErr) {
+		t.Errorf("error: %v, expected ErrCacheCorrupt wrapping the fetch error", err)
+	}
+
+	_, err = New(path, []byte("short"))
+	if !errors.Is(err, ErrCacheKey) {
+		t.Errorf("error: %v, expected ErrCacheKey", err)
+	}
+}
+
+func TestCacheMissing(t *testing.T) {
+	c, err := New(filepath.Join(t.TempDir(), "missing.cache"), bytes.Repeat([]byte("k"), 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Fallback("aws-secrets:/app/db", errors.New("unreachable"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error: %v, expected os.ErrNotExist", err)
+	}
+	if c.Status().Stale {
+		t.Error("stale without a cached value")
+	}
+}
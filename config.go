@@ -9,6 +9,7 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 
+	"github.com/litsea/viper-aws/cache"
 	"github.com/litsea/viper-aws/log"
 	"github.com/litsea/viper-aws/parameterstore"
 	"github.com/litsea/viper-aws/remote"
@@ -80,6 +81,56 @@ func NewSecrets(v *viper.Viper, sid string, vos []Option, pos []secrets.Option)
 	return cfg, nil
 }
 
+// NewMultiSecrets reads the JSON documents of multiple secrets, merged in order,
+// later secrets take priority
+func NewMultiSecrets(v *viper.Viper, sids []string, vos []Option, pos []secrets.Option) (*Config, error) {
+	p, err := secrets.NewMultiConfigProvider(sids, pos...)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.NewMultiSecrets: NewMultiConfigProvider, %w", err)
+	}
+
+	vos = append(vos, WithProvider(p))
+
+	cfg := New(v, vos...)
+	cfg.v.SetConfigType("json")
+	err = cfg.Read()
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.NewMultiSecrets: read failed, %w", err)
+	}
+
+	err = cfg.v.WatchRemoteConfigOnChannel()
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.NewMultiSecrets: WatchRemoteConfigOnChannel %w", err)
+	}
+
+	return cfg, nil
+}
+
+// NewBatchSecrets reads secrets with BatchGetSecretValue, chosen either by sids
+// or by secrets.WithBatchFilter options, each secret is mounted under a key derived from its name
+func NewBatchSecrets(v *viper.Viper, sids []string, vos []Option, pos []secrets.Option) (*Config, error) {
+	p, err := secrets.NewBatchConfigProvider(sids, pos...)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.NewBatchSecrets: NewBatchConfigProvider, %w", err)
+	}
+
+	vos = append(vos, WithProvider(p))
+
+	cfg := New(v, vos...)
+	cfg.v.SetConfigType("json")
+	err = cfg.Read()
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.NewBatchSecrets: read failed, %w", err)
+	}
+
+	err = cfg.v.WatchRemoteConfigOnChannel()
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.NewBatchSecrets: WatchRemoteConfigOnChannel %w", err)
+	}
+
+	return cfg, nil
+}
+
 func NewParameterStore(
 	v *viper.Viper, bp string, vos []Option, pos []parameterstore.Option,
 ) (*Config, error) {
@@ -108,10 +159,80 @@ func NewParameterStore(
 	return cfg, nil
 }
 
+// NewLayeredParameterStore reads multiple base paths merged in order,
+// later base paths override earlier ones, e.g. /common/, /app-a/common/, /app-a/prod/
+func NewLayeredParameterStore(
+	v *viper.Viper, bps []string, vos []Option, pos []parameterstore.Option,
+) (*Config, error) {
+	pos = append(pos,
+		parameterstore.WithBasePaths(bps...),
+	)
+	p, err := parameterstore.NewConfigProvider(pos...)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.NewLayeredParameterStore: NewConfigProvider, %w", err)
+	}
+
+	vos = append(vos, WithProvider(p))
+
+	cfg := New(v, vos...)
+	cfg.v.SetConfigType("json")
+	err = cfg.Read()
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.NewLayeredParameterStore: read failed, %w", err)
+	}
+
+	err = cfg.v.WatchRemoteConfigOnChannel()
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.NewLayeredParameterStore: WatchRemoteConfigOnChannel %w", err)
+	}
+
+	return cfg, nil
+}
+
+// NewNamedParameterStore reads the parameters by name, keyed by the viper key,
+// e.g. {"db.host": "/shared/db/host"}
+func NewNamedParameterStore(
+	v *viper.Viper, names map[string]string, vos []Option, pos []parameterstore.Option,
+) (*Config, error) {
+	pos = append(pos,
+		parameterstore.WithNames(names),
+	)
+	p, err := parameterstore.NewConfigProvider(pos...)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.NewNamedParameterStore: NewConfigProvider, %w", err)
+	}
+
+	vos = append(vos, WithProvider(p))
+
+	cfg := New(v, vos...)
+	cfg.v.SetConfigType("json")
+	err = cfg.Read()
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.NewNamedParameterStore: read failed, %w", err)
+	}
+
+	err = cfg.v.WatchRemoteConfigOnChannel()
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.NewNamedParameterStore: WatchRemoteConfigOnChannel %w", err)
+	}
+
+	return cfg, nil
+}
+
 func (c *Config) V() *viper.Viper {
 	return c.v
 }
 
+// Status returns the status of the config, stale when the provider read it from its cache
+func (c *Config) Status() cache.Status {
+	p, ok := c.provider.(interface{ CacheStatus() cache.Status })
+	if !ok {
+		return cache.Status{}
+	}
+
+	return p.CacheStatus()
+}
+
 func (c *Config) Read() error {
 	var err error
 
@@ -0,0 +1,199 @@
+package viperaws
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/spf13/viper"
+
+	"github.com/litsea/viper-aws/fake"
+	"github.com/litsea/viper-aws/internal/testutil"
+	"github.com/litsea/viper-aws/parameterstore"
+	"github.com/litsea/viper-aws/secrets"
+)
+
+// waitFor waits for a value from the provider onChange callback,
+// viper itself is not safe to read while the remote watcher updates it
+func waitFor(t *testing.T, ch <-chan string) string {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change received from watcher")
+		return ""
+	}
+}
+
+func TestNewSecretsOffline(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app-a/test", `{"foo":"v1"}`)
+
+	srv := fake.NewServer(sm, nil)
+	defer srv.Close()
+
+	changed := make(chan string, 1)
+
+	cfg, err := NewSecrets(viper.New(), "/app-a/test", []Option{WithType("json")}, []secrets.Option{
+		secrets.WithEndpoint(srv.URL),
+		secrets.WithAccessKey("test"),
+		secrets.WithSecretKey("test"),
+		secrets.WithUpdateStage(true),
+		secrets.WithWatchInterval(1100 * time.Millisecond),
+		secrets.WithOnChangeFunc(func(out *secretsmanager.GetSecretValueOutput) {
+			changed <- *out.SecretString
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cfg.V().GetString("foo"); got != "v1" {
+		t.Errorf("foo: %q, expected %q", got, "v1")
+	}
+	if n := sm.Calls("UpdateSecretVersionStage"); n != 1 {
+		t.Errorf("UpdateSecretVersionStage calls: %d, expected 1", n)
+	}
+
+	sm.PutSecretString("/app-a/test", `{"foo":"v2"}`)
+
+	if got := waitFor(t, changed); got != `{"foo":"v2"}` {
+		t.Errorf("changed secret: %s", got)
+	}
+}
+
+func TestNewBatchSecretsOffline(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app-a/prod/db", `{"host":"db1"}`)
+	sm.PutSecretString("/app-a/prod/api/token", "t1")
+
+	srv := fake.NewServer(sm, nil)
+	defer srv.Close()
+
+	changed := make(chan string, 1)
+
+	cfg, err := NewBatchSecrets(viper.New(), nil, nil, []secrets.Option{
+		secrets.WithEndpoint(srv.URL),
+		secrets.WithAccessKey("test"),
+		secrets.WithSecretKey("test"),
+		secrets.WithBatchFilter("name", "/app-a/prod/"),
+		secrets.WithWatchInterval(1100 * time.Millisecond),
+		secrets.WithOnChangesFunc(func(changes *secrets.Changes) {
+			changed <- strings.Join(changes.Updated, ",")
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cfg.V().GetString("db.host"); got != "db1" {
+		t.Errorf("db.host: %q, expected %q", got, "db1")
+	}
+	if got := cfg.V().GetString("api.token"); got != "t1" {
+		t.Errorf("api.token: %q, expected %q", got, "t1")
+	}
+
+	sm.PutSecretString("/app-a/prod/db", `{"host":"db2"}`)
+
+	if got := waitFor(t, changed); got != "db.host" {
+		t.Errorf("changed keys: %s", got)
+	}
+}
+
+func TestNewParameterStoreOffline(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+
+	s := fake.NewSSM()
+	s.PutParameter("/app-a/test/foo", "v1", types.ParameterTypeString)
+	s.PutParameter("/app-a/test/bar", "secret", types.ParameterTypeSecureString)
+
+	srv := fake.NewServer(nil, s)
+	defer srv.Close()
+
+	changed := make(chan string, 1)
+
+	cfg, err := NewParameterStore(viper.New(), "/app-a/test", nil, []parameterstore.Option{
+		parameterstore.WithEndpoint(srv.URL),
+		parameterstore.WithAccessKey("test"),
+		parameterstore.WithSecretKey("test"),
+		parameterstore.WithWatchInterval(1100 * time.Millisecond),
+		parameterstore.WithOnChangeFunc(func(ps *parameterstore.Parameters, _ *parameterstore.Changes) {
+			changed <- ps.GetValueByName("foo")
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cfg.V().GetString("bar"); got != "secret" {
+		t.Errorf("bar: %q, expected %q", got, "secret")
+	}
+
+	s.PutParameter("/app-a/test/foo", "v2", types.ParameterTypeString)
+
+	if got := waitFor(t, changed); got != "v2" {
+		t.Errorf("changed foo: %q", got)
+	}
+}
+
+func TestNewSecretsStatus(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+
+	c := testutil.NewCache(t, filepath.Join(t.TempDir(), "app.cache"))
+	err := c.Save("aws-secrets:/app-a/status", []byte(`{"foo":"v1"}`), map[string]string{"/app-a/status": "v0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The secret cannot be read at startup
+	sm := fake.NewSecretsManager()
+
+	srv := fake.NewServer(sm, nil)
+	defer srv.Close()
+
+	changed := make(chan string, 1)
+
+	cfg, err := NewSecrets(viper.New(), "/app-a/status", []Option{WithType("json")}, []secrets.Option{
+		secrets.WithEndpoint(srv.URL),
+		secrets.WithAccessKey("test"),
+		secrets.WithSecretKey("test"),
+		secrets.WithCache(c),
+		secrets.WithWatchInterval(1100 * time.Millisecond),
+		secrets.WithOnChangeFunc(func(out *secretsmanager.GetSecretValueOutput) {
+			changed <- *out.SecretString
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cfg.V().GetString("foo"); got != "v1" {
+		t.Errorf("foo: %q, expected %q", got, "v1")
+	}
+	if st := cfg.Status(); !st.Stale || st.Err == nil || st.Versions["/app-a/status"] != "v0" {
+		t.Errorf("status: %+v, expected stale", st)
+	}
+
+	vid := sm.PutSecretString("/app-a/status", `{"foo":"v2"}`)
+
+	if got := waitFor(t, changed); got != `{"foo":"v2"}` {
+		t.Errorf("changed secret: %s", got)
+	}
+	if st := cfg.Status(); st.Stale || st.Versions["/app-a/status"] != vid {
+		t.Errorf("status: %+v, expected fresh", st)
+	}
+
+	// Without a cache the status is never stale
+	if st := New(viper.New()).Status(); st.Stale {
+		t.Errorf("status: %+v", st)
+	}
+}
@@ -27,6 +27,10 @@ func main() {
 	//   WithAccessKey()
 	//   WithSecretKey()
 	//   WithSessionToken()
+	// Shared config profile, custom endpoint or an existing AWS config:
+	//   WithProfile()
+	//   WithEndpoint()
+	//   WithAWSConfig()
 	basePath := "/app-a/local/"
 	cfg, err := vp.NewParameterStore(v, basePath, []vp.Option{}, []parameterstore.Option{
 		parameterstore.WithRegion("us-east-1"),
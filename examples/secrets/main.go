@@ -28,6 +28,10 @@ func main() {
 	//   WithAccessKey()
 	//   WithSecretKey()
 	//   WithSessionToken()
+	// Shared config profile, custom endpoint or an existing AWS config:
+	//   WithProfile()
+	//   WithEndpoint()
+	//   WithAWSConfig()
 	sid := "/app-a/local/test"
 	cfg, err := vp.NewSecrets(v, sid, []vp.Option{}, []secrets.Option{
 		secrets.WithRegion("us-east-1"),
@@ -0,0 +1,128 @@
+package fake_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+
+	"github.com/litsea/viper-aws/fake"
+	"github.com/litsea/viper-aws/parameterstore"
+	"github.com/litsea/viper-aws/secrets"
+)
+
+var (
+	_ secrets.Client        = (*fake.SecretsManager)(nil)
+	_ parameterstore.Client = (*fake.SSM)(nil)
+)
+
+func TestSecretsManagerStages(t *testing.T) {
+	ctx := context.Background()
+	sm := fake.NewSecretsManager()
+	v1 := sm.PutSecretString("/app/test", `{"foo":"v1"}`)
+	v2 := sm.PutSecretString("/app/test", `{"foo":"v2"}`)
+
+	out, err := sm.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String("/app/test")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := out.VersionIdsToStages[v1]; len(got) != 1 || got[0] != "AWSPREVIOUS" {
+		t.Errorf("version 1 stages: %v, expected [AWSPREVIOUS]", got)
+	}
+	if got := out.VersionIdsToStages[v2]; len(got) != 1 || got[0] != "AWSCURRENT" {
+		t.Errorf("version 2 stages: %v, expected [AWSCURRENT]", got)
+	}
+
+	_, err = sm.UpdateSecretVersionStage(ctx, &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:        aws.String("/app/test"),
+		VersionStage:    aws.String("AWSCURRENT"),
+		MoveToVersionId: aws.String(v1),
+	})
+	var ipe *smtypes.InvalidParameterException
+	if !errors.As(err, &ipe) {
+		t.Errorf("moving an attached stage without RemoveFromVersionId: %v, expected InvalidParameterException", err)
+	}
+
+	_, err = sm.UpdateSecretVersionStage(ctx, &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            aws.String("/app/test"),
+		VersionStage:        aws.String("AWSCURRENT"),
+		MoveToVersionId:     aws.String(v1),
+		RemoveFromVersionId: aws.String(v2),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sm.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String("/app/test")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.VersionId != v1 || *got.SecretString != `{"foo":"v1"}` {
+		t.Errorf("AWSCURRENT version: %s, expected %s", *got.VersionId, v1)
+	}
+
+	got, err = sm.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String("/app/test"),
+		VersionStage: aws.String("AWSPREVIOUS"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.VersionId != v2 {
+		t.Errorf("AWSPREVIOUS version: %s, expected %s", *got.VersionId, v2)
+	}
+}
+
+func TestSSMGetParametersByPath(t *testing.T) {
+	ctx := context.Background()
+	s := fake.NewSSM()
+	for _, name := range []string{"/app/a", "/app/b", "/app/c", "/app/db/host", "/other/a"} {
+		s.PutParameter(name, name, types.ParameterTypeString)
+	}
+	if v := s.PutParameter("/app/a", "a2", types.ParameterTypeString); v != 2 {
+		t.Errorf("version after second put: %d, expected 2", v)
+	}
+
+	tests := []struct {
+		recursive bool
+		want      int
+	}{
+		{false, 3},
+		{true, 4},
+	}
+
+	for _, tt := range tests {
+		var (
+			next  *string
+			names []string
+		)
+		for {
+			out, err := s.GetParametersByPath(ctx, &ssm.GetParametersByPathInput{
+				Path:       aws.String("/app/"),
+				Recursive:  aws.Bool(tt.recursive),
+				MaxResults: aws.Int32(2),
+				NextToken:  next,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range out.Parameters {
+				names = append(names, *p.Name)
+			}
+			if out.NextToken == nil {
+				break
+			}
+			next = out.NextToken
+		}
+
+		if len(names) != tt.want {
+			t.Errorf("recursive=%v parameters: %v, expected %d", tt.recursive, names, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package fake
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// paginate returns a page of items, NextToken is the offset of the next page,
+// ok is false when the token is invalid
+func paginate[T any](items []T, token *string, maxResults *int32, defaultMax int32) ([]T, *string, bool) {
+	start := 0
+	if token != nil {
+		n, err := strconv.Atoi(*token)
+		if err != nil || n < 0 || n > len(items) {
+			return nil, nil, false
+		}
+		start = n
+	}
+
+	size := int(defaultMax)
+	if maxResults != nil && *maxResults > 0 && *maxResults < defaultMax {
+		size = int(*maxResults)
+	}
+
+	end := min(start+size, len(items))
+	if end == len(items) {
+		return items[start:end], nil, true
+	}
+
+	return items[start:end], aws.String(strconv.Itoa(end)), true
+}
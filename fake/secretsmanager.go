@@ -0,0 +1,555 @@
+// Package fake holds in-memory Secrets Manager and SSM backends for tests,
+// they implement secrets.Client and parameterstore.Client
+package fake
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+const (
+	region    = "us-east-1"
+	accountID = "123456789012"
+
+	stageCurrent  = "AWSCURRENT"
+	stagePrevious = "AWSPREVIOUS"
+
+	// Staging labels attached across all versions of a secret
+	maxSecretStages = 20
+)
+
+type secret struct {
+	name        string
+	arn         string
+	description string
+	tags        map[string]string
+	created     time.Time
+	changed     time.Time
+	versions    []*secretVersion
+}
+
+type secretVersion struct {
+	id      string
+	str     *string
+	bin     []byte
+	stages  []string
+	created time.Time
+}
+
+// SecretsManager is an in-memory Secrets Manager backend,
+// versions and staging labels behave like AWS: putting a new value moves AWSCURRENT
+// to the new version and AWSPREVIOUS to the former current version
+type SecretsManager struct {
+	mu      sync.Mutex
+	secrets map[string]*secret
+	seq     int
+	calls   map[string]int
+}
+
+func NewSecretsManager() *SecretsManager {
+	return &SecretsManager{
+		secrets: make(map[string]*secret),
+		calls:   make(map[string]int),
+	}
+}
+
+// PutSecretString stores a new string version and returns its version ID,
+// the version gets AWSCURRENT when no stages are given
+func (sm *SecretsManager) PutSecretString(id, value string, stages ...string) string {
+	return sm.put(id, &secretVersion{str: aws.String(value)}, stages)
+}
+
+// PutSecretBinary stores a new binary version and returns its version ID,
+// the version gets AWSCURRENT when no stages are given
+func (sm *SecretsManager) PutSecretBinary(id string, value []byte, stages ...string) string {
+	return sm.put(id, &secretVersion{bin: slices.Clone(value)}, stages)
+}
+
+// TagSecret sets the tags of a secret, used by BatchGetSecretValue tag filters
+func (sm *SecretsManager) TagSecret(id string, tags map[string]string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	s := sm.lookup(id)
+	if s == nil {
+		return
+	}
+
+	for k, v := range tags {
+		s.tags[k] = v
+	}
+}
+
+// DeleteSecret removes a secret and all its versions immediately
+func (sm *SecretsManager) DeleteSecret(id string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	s := sm.lookup(id)
+	if s != nil {
+		delete(sm.secrets, s.name)
+	}
+}
+
+// Calls returns how many times the API operation was called, e.g. "GetSecretValue"
+func (sm *SecretsManager) Calls(op string) int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return sm.calls[op]
+}
+
+func (sm *SecretsManager) put(id string, v *secretVersion, stages []string) string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	now := time.Now()
+	s := sm.lookup(id)
+	if s == nil {
+		s = &secret{
+			name:    id,
+			arn:     secretARN(id),
+			tags:    make(map[string]string),
+			created: now,
+		}
+		sm.secrets[id] = s
+	}
+
+	if len(stages) == 0 {
+		stages = []string{stageCurrent}
+	}
+
+	sm.seq++
+	v.id = fmt.Sprintf("%08x-0000-4000-8000-%012x", sm.seq, sm.seq)
+	v.created = now
+	s.versions = append(s.versions, v)
+	s.changed = now
+
+	for _, stg := range stages {
+		s.moveStage(stg, v)
+	}
+
+	return v.id
+}
+
+func (sm *SecretsManager) lookup(id string) *secret {
+	if s, ok := sm.secrets[id]; ok {
+		return s
+	}
+
+	for _, s := range sm.secrets {
+		if s.arn == id {
+			return s
+		}
+	}
+
+	return nil
+}
+
+func (sm *SecretsManager) call(op string) {
+	sm.mu.Lock()
+	sm.calls[op]++
+	sm.mu.Unlock()
+}
+
+// secretARN builds an ARN with the random 6 characters suffix AWS appends to secret names
+func secretARN(name string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	suffix := strconv.FormatUint(uint64(h.Sum32()), 36)
+	suffix = (suffix + "aaaaaa")[:6]
+
+	return fmt.Sprintf("arn:aws:secretsmanager:%s:%s:secret:%s-%s", region, accountID, name, suffix)
+}
+
+func (s *secret) version(id string) *secretVersion {
+	for _, v := range s.versions {
+		if v.id == id {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func (s *secret) versionByStage(stg string) *secretVersion {
+	for _, v := range s.versions {
+		if slices.Contains(v.stages, stg) {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func (s *secret) stageCount() int {
+	n := 0
+	for _, v := range s.versions {
+		n += len(v.stages)
+	}
+
+	return n
+}
+
+// moveStage attaches stg to v and detaches it from any other version,
+// moving AWSCURRENT also moves AWSPREVIOUS to the former current version
+func (s *secret) moveStage(stg string, v *secretVersion) {
+	old := s.versionByStage(stg)
+	if old == v {
+		return
+	}
+
+	if old != nil {
+		old.stages = slices.DeleteFunc(old.stages, func(x string) bool { return x == stg })
+	}
+	v.stages = append(v.stages, stg)
+
+	if stg == stageCurrent && old != nil {
+		s.moveStage(stagePrevious, old)
+	}
+}
+
+func (s *secret) valueEntry(v *secretVersion) types.SecretValueEntry {
+	return types.SecretValueEntry{
+		ARN:           aws.String(s.arn),
+		CreatedDate:   aws.Time(v.created),
+		Name:          aws.String(s.name),
+		SecretBinary:  slices.Clone(v.bin),
+		SecretString:  v.str,
+		VersionId:     aws.String(v.id),
+		VersionStages: slices.Clone(v.stages),
+	}
+}
+
+func notFound(id string) error {
+	return &types.ResourceNotFoundException{
+		Message: aws.String("Secrets Manager can't find the specified secret: " + id),
+	}
+}
+
+func invalidNextToken() error {
+	return &types.InvalidNextTokenException{
+		Message: aws.String("The NextToken value is invalid"),
+	}
+}
+
+func invalidParameter(format string, args ...any) error {
+	return &types.InvalidParameterException{
+		Message: aws.String(fmt.Sprintf(format, args...)),
+	}
+}
+
+func (sm *SecretsManager) GetSecretValue(_ context.Context, params *secretsmanager.GetSecretValueInput,
+	_ ...func(*secretsmanager.Options),
+) (*secretsmanager.GetSecretValueOutput, error) {
+	sm.call("GetSecretValue")
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	id := aws.ToString(params.SecretId)
+	s := sm.lookup(id)
+	if s == nil {
+		return nil, notFound(id)
+	}
+
+	var v *secretVersion
+	stg := aws.ToString(params.VersionStage)
+	if params.VersionId != nil {
+		v = s.version(*params.VersionId)
+		if v != nil && stg != "" && !slices.Contains(v.stages, stg) {
+			v = nil
+		}
+	} else {
+		if stg == "" {
+			stg = stageCurrent
+		}
+		v = s.versionByStage(stg)
+	}
+
+	if v == nil {
+		return nil, &types.ResourceNotFoundException{
+			Message: aws.String(fmt.Sprintf(
+				"Secrets Manager can't find the specified secret value for VersionId: %s, staging label: %s",
+				aws.ToString(params.VersionId), stg)),
+		}
+	}
+
+	e := s.valueEntry(v)
+
+	return &secretsmanager.GetSecretValueOutput{
+		ARN:           e.ARN,
+		CreatedDate:   e.CreatedDate,
+		Name:          e.Name,
+		SecretBinary:  e.SecretBinary,
+		SecretString:  e.SecretString,
+		VersionId:     e.VersionId,
+		VersionStages: e.VersionStages,
+	}, nil
+}
+
+func (sm *SecretsManager) DescribeSecret(_ context.Context, params *secretsmanager.DescribeSecretInput,
+	_ ...func(*secretsmanager.Options),
+) (*secretsmanager.DescribeSecretOutput, error) {
+	sm.call("DescribeSecret")
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	id := aws.ToString(params.SecretId)
+	s := sm.lookup(id)
+	if s == nil {
+		return nil, notFound(id)
+	}
+
+	vs := make(map[string][]string)
+	for _, v := range s.versions {
+		if len(v.stages) > 0 {
+			vs[v.id] = slices.Clone(v.stages)
+		}
+	}
+
+	tags := make([]types.Tag, 0, len(s.tags))
+	for k, v := range s.tags {
+		tags = append(tags, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	return &secretsmanager.DescribeSecretOutput{
+		ARN:                aws.String(s.arn),
+		Name:               aws.String(s.name),
+		Description:        aws.String(s.description),
+		CreatedDate:        aws.Time(s.created),
+		LastChangedDate:    aws.Time(s.changed),
+		Tags:               tags,
+		VersionIdsToStages: vs,
+	}, nil
+}
+
+func (sm *SecretsManager) ListSecretVersionIds(_ context.Context, params *secretsmanager.ListSecretVersionIdsInput,
+	_ ...func(*secretsmanager.Options),
+) (*secretsmanager.ListSecretVersionIdsOutput, error) {
+	sm.call("ListSecretVersionIds")
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	id := aws.ToString(params.SecretId)
+	s := sm.lookup(id)
+	if s == nil {
+		return nil, notFound(id)
+	}
+
+	entries := make([]types.SecretVersionsListEntry, 0, len(s.versions))
+	for _, v := range s.versions {
+		// Versions without staging labels are deprecated
+		if len(v.stages) == 0 && !aws.ToBool(params.IncludeDeprecated) {
+			continue
+		}
+
+		entries = append(entries, types.SecretVersionsListEntry{
+			CreatedDate:   aws.Time(v.created),
+			VersionId:     aws.String(v.id),
+			VersionStages: slices.Clone(v.stages),
+		})
+	}
+
+	page, next, ok := paginate(entries, params.NextToken, params.MaxResults, 100)
+	if !ok {
+		return nil, invalidNextToken()
+	}
+
+	return &secretsmanager.ListSecretVersionIdsOutput{
+		ARN:       aws.String(s.arn),
+		Name:      aws.String(s.name),
+		NextToken: next,
+		Versions:  page,
+	}, nil
+}
+
+func (sm *SecretsManager) UpdateSecretVersionStage(_ context.Context,
+	params *secretsmanager.UpdateSecretVersionStageInput, _ ...func(*secretsmanager.Options),
+) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	sm.call("UpdateSecretVersionStage")
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	id := aws.ToString(params.SecretId)
+	s := sm.lookup(id)
+	if s == nil {
+		return nil, notFound(id)
+	}
+
+	stg := aws.ToString(params.VersionStage)
+	if stg == "" {
+		return nil, invalidParameter("You must specify a VersionStage")
+	}
+
+	attached := s.versionByStage(stg)
+
+	if params.RemoveFromVersionId != nil {
+		from := s.version(*params.RemoveFromVersionId)
+		if from == nil || from != attached {
+			return nil, invalidParameter("Staging label %s isn't currently attached to version %s",
+				stg, *params.RemoveFromVersionId)
+		}
+	}
+
+	if params.MoveToVersionId != nil {
+		to := s.version(*params.MoveToVersionId)
+		if to == nil {
+			return nil, &types.ResourceNotFoundException{
+				Message: aws.String("Secrets Manager can't find the specified secret version: " +
+					*params.MoveToVersionId),
+			}
+		}
+
+		if attached != nil && attached != to && params.RemoveFromVersionId == nil {
+			return nil, invalidParameter(
+				"The parameter RemoveFromVersionId can't be empty. Staging label %s is "+
+					"currently attached to version %s, so you must explicitly reference that version in "+
+					"RemoveFromVersionId", stg, attached.id)
+		}
+
+		if attached == nil && s.stageCount() >= maxSecretStages {
+			return nil, &types.LimitExceededException{
+				Message: aws.String(fmt.Sprintf("You can attach at most %d staging labels to a secret",
+					maxSecretStages)),
+			}
+		}
+
+		s.moveStage(stg, to)
+	} else if attached != nil {
+		if stg == stageCurrent {
+			return nil, invalidParameter("You can't remove the staging label %s", stageCurrent)
+		}
+		attached.stages = slices.DeleteFunc(attached.stages, func(x string) bool { return x == stg })
+	}
+
+	s.changed = time.Now()
+
+	return &secretsmanager.UpdateSecretVersionStageOutput{
+		ARN:  aws.String(s.arn),
+		Name: aws.String(s.name),
+	}, nil
+}
+
+func (sm *SecretsManager) BatchGetSecretValue(_ context.Context, params *secretsmanager.BatchGetSecretValueInput,
+	_ ...func(*secretsmanager.Options),
+) (*secretsmanager.BatchGetSecretValueOutput, error) {
+	sm.call("BatchGetSecretValue")
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if (len(params.SecretIdList) > 0) == (len(params.Filters) > 0) {
+		return nil, invalidParameter("You must include Filters or SecretIdList, but not both")
+	}
+
+	out := &secretsmanager.BatchGetSecretValueOutput{}
+
+	if len(params.SecretIdList) > 0 {
+		if len(params.SecretIdList) > 20 || params.MaxResults != nil || params.NextToken != nil {
+			return nil, invalidParameter("SecretIdList accepts at most 20 secret IDs and no pagination")
+		}
+
+		for _, id := range params.SecretIdList {
+			s := sm.lookup(id)
+			var v *secretVersion
+			if s != nil {
+				v = s.versionByStage(stageCurrent)
+			}
+			if v == nil {
+				out.Errors = append(out.Errors, types.APIErrorType{
+					ErrorCode: aws.String("ResourceNotFoundException"),
+					Message:   aws.String("Secrets Manager can't find the specified secret."),
+					SecretId:  aws.String(id),
+				})
+				continue
+			}
+			out.SecretValues = append(out.SecretValues, s.valueEntry(v))
+		}
+
+		return out, nil
+	}
+
+	names := make([]string, 0, len(sm.secrets))
+	for name, s := range sm.secrets {
+		if matchFilters(s, params.Filters) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	page, next, ok := paginate(names, params.NextToken, params.MaxResults, 20)
+	if !ok {
+		return nil, invalidNextToken()
+	}
+
+	for _, name := range page {
+		s := sm.secrets[name]
+		if v := s.versionByStage(stageCurrent); v != nil {
+			out.SecretValues = append(out.SecretValues, s.valueEntry(v))
+		}
+	}
+	out.NextToken = next
+
+	return out, nil
+}
+
+// matchFilters reports whether the secret matches all filters,
+// values of a filter are prefix matches combined with OR, a "!" prefix negates the value
+func matchFilters(s *secret, filters []types.Filter) bool {
+	for _, f := range filters {
+		var fields []string
+		switch f.Key {
+		case types.FilterNameStringTypeName:
+			fields = []string{s.name}
+		case types.FilterNameStringTypeDescription:
+			fields = []string{s.description}
+		case types.FilterNameStringTypeTagKey:
+			for k := range s.tags {
+				fields = append(fields, k)
+			}
+		case types.FilterNameStringTypeTagValue:
+			for _, v := range s.tags {
+				fields = append(fields, v)
+			}
+		default:
+			fields = []string{s.name, s.description}
+		}
+
+		if !matchFilter(fields, f.Values) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchFilter(fields, values []string) bool {
+	for _, v := range values {
+		neg := strings.HasPrefix(v, "!")
+		v = strings.TrimPrefix(v, "!")
+
+		matched := slices.ContainsFunc(fields, func(f string) bool {
+			return strings.HasPrefix(f, v)
+		})
+
+		if matched != neg {
+			return true
+		}
+	}
+
+	return false
+}
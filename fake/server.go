@@ -0,0 +1,154 @@
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// NewServer starts an HTTP server speaking the AWS JSON 1.1 protocol for the operations
+// the providers use, backed by sm and s (either may be nil).
+// Point the providers at it with secrets.WithEndpoint / parameterstore.WithEndpoint,
+// the caller must Close the server.
+func NewServer(sm *SecretsManager, s *SSM) *httptest.Server {
+	ops := make(map[string]func(body []byte) (any, error))
+
+	if sm != nil {
+		ops["secretsmanager.GetSecretValue"] = operation(sm.GetSecretValue)
+		ops["secretsmanager.DescribeSecret"] = operation(sm.DescribeSecret)
+		ops["secretsmanager.ListSecretVersionIds"] = operation(sm.ListSecretVersionIds)
+		ops["secretsmanager.UpdateSecretVersionStage"] = operation(sm.UpdateSecretVersionStage)
+		ops["secretsmanager.BatchGetSecretValue"] = operation(sm.BatchGetSecretValue)
+	}
+
+	if s != nil {
+		ops["AmazonSSM.GetParametersByPath"] = operation(s.GetParametersByPath)
+		ops["AmazonSSM.DescribeParameters"] = operation(s.DescribeParameters)
+		ops["AmazonSSM.GetParameterHistory"] = operation(s.GetParameterHistory)
+		ops["AmazonSSM.GetParameters"] = operation(s.GetParameters)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get("X-Amz-Target")
+		op, ok := ops[target]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "UnknownOperationException", "unknown operation "+target)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "SerializationException", err.Error())
+			return
+		}
+
+		out, err := op(body)
+		if err != nil {
+			var ae smithy.APIError
+			if errors.As(err, &ae) {
+				writeError(w, http.StatusBadRequest, ae.ErrorCode(), ae.ErrorMessage())
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "InternalFailure", err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		_ = json.NewEncoder(w).Encode(wireValue(reflect.ValueOf(out)))
+	}))
+}
+
+// operation adapts an SDK style API method to a JSON request handler
+func operation[I, O, F any](fn func(context.Context, *I, ...F) (*O, error)) func(body []byte) (any, error) {
+	return func(body []byte) (any, error) {
+		in := new(I)
+		if len(body) > 0 {
+			err := json.Unmarshal(body, in)
+			if err != nil {
+				return nil, &smithy.GenericAPIError{Code: "SerializationException", Message: err.Error()}
+			}
+		}
+
+		return fn(context.Background(), in)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.Header().Set("X-Amzn-ErrorType", code)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"__type":  code,
+		"message": msg,
+	})
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// wireValue converts an SDK output to its AWS JSON 1.1 shape:
+// timestamps are epoch seconds, nil and metadata fields are omitted
+func wireValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return wireValue(v.Elem())
+	case reflect.Struct:
+		if v.Type() == timeType {
+			//nolint:forcetypeassert
+			return float64(v.Interface().(time.Time).UnixMilli()) / 1000
+		}
+
+		m := make(map[string]any)
+		for i := range v.NumField() {
+			f := v.Type().Field(i)
+			if !f.IsExported() || f.Name == "ResultMetadata" {
+				continue
+			}
+			if fv := wireValue(v.Field(i)); fv != nil {
+				m[f.Name] = fv
+			}
+		}
+		return m
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes()
+		}
+
+		s := make([]any, 0, v.Len())
+		for i := range v.Len() {
+			s = append(s, wireValue(v.Index(i)))
+		}
+		return s
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+
+		m := make(map[string]any, v.Len())
+		for _, k := range v.MapKeys() {
+			m[k.String()] = wireValue(v.MapIndex(k))
+		}
+		return m
+	case reflect.String:
+		if v.Len() == 0 && strings.HasSuffix(v.Type().PkgPath(), "/types") {
+			// Unset enum
+			return nil
+		}
+		return v.String()
+	default:
+		return v.Interface()
+	}
+}
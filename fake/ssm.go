@@ -0,0 +1,486 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+type parameter struct {
+	name    string
+	tags    map[string]string
+	history []*parameterVersion
+}
+
+type parameterVersion struct {
+	value       string
+	typ         types.ParameterType
+	dataType    string
+	description string
+	version     int64
+	labels      []string
+	modified    time.Time
+}
+
+const (
+	defaultKeyID = "alias/aws/ssm"
+	modifiedUser = "arn:aws:iam::" + accountID + ":user/fake"
+)
+
+// SSM is an in-memory Parameter Store backend,
+// every put bumps the parameter version like AWS does
+type SSM struct {
+	mu         sync.Mutex
+	parameters map[string]*parameter
+	calls      map[string]int
+}
+
+func NewSSM() *SSM {
+	return &SSM{
+		parameters: make(map[string]*parameter),
+		calls:      make(map[string]int),
+	}
+}
+
+// PutParameter stores a new version of the parameter and returns the version number
+func (s *SSM) PutParameter(name, value string, typ types.ParameterType) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.parameters[name]
+	if !ok {
+		p = &parameter{
+			name: name,
+			tags: make(map[string]string),
+		}
+		s.parameters[name] = p
+	}
+
+	v := &parameterVersion{
+		value:    value,
+		typ:      typ,
+		dataType: "text",
+		version:  int64(len(p.history)) + 1,
+		modified: time.Now(),
+	}
+	p.history = append(p.history, v)
+
+	return v.version
+}
+
+// LabelParameterVersion attaches labels to a version of the parameter,
+// a label moves from any other version of the parameter like AWS does
+func (s *SSM) LabelParameterVersion(name string, version int64, labels ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.parameters[name]
+	if !ok || version < 1 || version > int64(len(p.history)) {
+		return
+	}
+
+	for _, l := range labels {
+		for _, v := range p.history {
+			v.labels = slices.DeleteFunc(v.labels, func(x string) bool { return x == l })
+		}
+		v := p.history[version-1]
+		v.labels = append(v.labels, l)
+	}
+}
+
+// SetParameterDescription sets the description of the latest version of the parameter
+func (s *SSM) SetParameterDescription(name, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.parameters[name]; ok {
+		p.latest().description = description
+	}
+}
+
+// TagParameter sets tags of the parameter, used by DescribeParameters tag filters
+func (s *SSM) TagParameter(name string, tags map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.parameters[name]
+	if !ok {
+		return
+	}
+
+	for k, v := range tags {
+		p.tags[k] = v
+	}
+}
+
+// DeleteParameter removes a parameter and its history
+func (s *SSM) DeleteParameter(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.parameters, name)
+}
+
+// Calls returns how many times the API operation was called, e.g. "GetParametersByPath"
+func (s *SSM) Calls(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[op]
+}
+
+func (s *SSM) call(op string) {
+	s.mu.Lock()
+	s.calls[op]++
+	s.mu.Unlock()
+}
+
+func parameterARN(name string) string {
+	return fmt.Sprintf("arn:aws:ssm:%s:%s:parameter/%s", region, accountID, strings.TrimPrefix(name, "/"))
+}
+
+func (p *parameter) latest() *parameterVersion {
+	return p.history[len(p.history)-1]
+}
+
+func (v *parameterVersion) keyID() *string {
+	if v.typ != types.ParameterTypeSecureString {
+		return nil
+	}
+
+	return aws.String(defaultKeyID)
+}
+
+func (p *parameter) labeled(labels []string) *parameterVersion {
+	for _, v := range p.history {
+		for _, l := range labels {
+			if slices.Contains(v.labels, l) {
+				return v
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *parameter) output(v *parameterVersion) types.Parameter {
+	return types.Parameter{
+		ARN:              aws.String(parameterARN(p.name)),
+		DataType:         aws.String(v.dataType),
+		LastModifiedDate: aws.Time(v.modified),
+		Name:             aws.String(p.name),
+		Type:             v.typ,
+		Value:            aws.String(v.value),
+		Version:          v.version,
+	}
+}
+
+// underPath reports whether name is below path,
+// only direct children match unless recursive
+func underPath(name, path string, recursive bool) bool {
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	rest, ok := strings.CutPrefix(name, path)
+	if !ok || rest == "" {
+		return false
+	}
+
+	return recursive || !strings.Contains(rest, "/")
+}
+
+func (s *SSM) sortedNames() []string {
+	names := make([]string, 0, len(s.parameters))
+	for name := range s.parameters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func (s *SSM) GetParametersByPath(_ context.Context, params *ssm.GetParametersByPathInput,
+	_ ...func(*ssm.Options),
+) (*ssm.GetParametersByPathOutput, error) {
+	s.call("GetParametersByPath")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := aws.ToString(params.Path)
+	if !strings.HasPrefix(path, "/") {
+		return nil, &types.ValidationException{
+			Message: aws.String("The parameter path must begin with a forward slash (/)"),
+		}
+	}
+
+	var label []string
+	for _, f := range params.ParameterFilters {
+		switch key := aws.ToString(f.Key); {
+		case key == "Label":
+			label = f.Values
+		case key == "Type" || key == "KeyId":
+		default:
+			return nil, &types.InvalidFilterKey{
+				Message: aws.String("The following filter key is not valid: " + key),
+			}
+		}
+	}
+
+	ps := make([]types.Parameter, 0)
+	for _, name := range s.sortedNames() {
+		if !underPath(name, path, aws.ToBool(params.Recursive)) {
+			continue
+		}
+
+		p := s.parameters[name]
+		v := p.latest()
+		if label != nil {
+			// The labeled version is returned
+			v = p.labeled(label)
+			if v == nil {
+				continue
+			}
+		}
+
+		if !matchParameterFilters(p, v, params.ParameterFilters) {
+			continue
+		}
+
+		ps = append(ps, p.output(v))
+	}
+
+	page, next, ok := paginate(ps, params.NextToken, params.MaxResults, 10)
+	if !ok {
+		return nil, &types.InvalidNextToken{
+			Message: aws.String("The NextToken value is invalid"),
+		}
+	}
+
+	return &ssm.GetParametersByPathOutput{
+		NextToken:  next,
+		Parameters: page,
+	}, nil
+}
+
+func (s *SSM) DescribeParameters(_ context.Context, params *ssm.DescribeParametersInput,
+	_ ...func(*ssm.Options),
+) (*ssm.DescribeParametersOutput, error) {
+	s.call("DescribeParameters")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, f := range params.ParameterFilters {
+		if aws.ToString(f.Key) == "Label" {
+			return nil, &types.InvalidFilterKey{
+				Message: aws.String("The following filter key is not valid: Label"),
+			}
+		}
+	}
+
+	ps := make([]types.ParameterMetadata, 0)
+	for _, name := range s.sortedNames() {
+		p := s.parameters[name]
+		v := p.latest()
+		if !matchParameterFilters(p, v, params.ParameterFilters) {
+			continue
+		}
+
+		ps = append(ps, types.ParameterMetadata{
+			ARN:              aws.String(parameterARN(p.name)),
+			DataType:         aws.String(v.dataType),
+			Description:      aws.String(v.description),
+			KeyId:            v.keyID(),
+			LastModifiedDate: aws.Time(v.modified),
+			LastModifiedUser: aws.String(modifiedUser),
+			Name:             aws.String(p.name),
+			Tier:             types.ParameterTierStandard,
+			Type:             v.typ,
+			Version:          v.version,
+		})
+	}
+
+	page, next, ok := paginate(ps, params.NextToken, params.MaxResults, 50)
+	if !ok {
+		return nil, &types.InvalidNextToken{
+			Message: aws.String("The NextToken value is invalid"),
+		}
+	}
+
+	return &ssm.DescribeParametersOutput{
+		NextToken:  next,
+		Parameters: page,
+	}, nil
+}
+
+// matchParameterFilters reports whether the parameter version matches all filters,
+// values of a filter are combined with OR
+func matchParameterFilters(p *parameter, v *parameterVersion, filters []types.ParameterStringFilter) bool {
+	for _, f := range filters {
+		key := aws.ToString(f.Key)
+		opt := aws.ToString(f.Option)
+
+		var fields []string
+		switch {
+		case key == "Path":
+			recursive := opt == "Recursive"
+			if !slices.ContainsFunc(f.Values, func(path string) bool {
+				return underPath(p.name, path, recursive)
+			}) {
+				return false
+			}
+			continue
+		case key == "Name":
+			fields = []string{p.name}
+		case key == "Type":
+			fields = []string{string(v.typ)}
+		case key == "DataType":
+			fields = []string{v.dataType}
+		case key == "Tier":
+			fields = []string{string(types.ParameterTierStandard)}
+		case strings.HasPrefix(key, "tag:"):
+			tv, ok := p.tags[strings.TrimPrefix(key, "tag:")]
+			if !ok {
+				return false
+			}
+			if len(f.Values) == 0 {
+				continue
+			}
+			fields = []string{tv}
+		default:
+			// Label is matched by the caller, KeyId is not stored
+			continue
+		}
+
+		if !slices.ContainsFunc(f.Values, func(value string) bool {
+			return slices.ContainsFunc(fields, func(field string) bool {
+				switch opt {
+				case "BeginsWith":
+					return strings.HasPrefix(field, value)
+				case "Contains":
+					return strings.Contains(field, value)
+				default:
+					return field == value
+				}
+			})
+		}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *SSM) GetParameterHistory(_ context.Context, params *ssm.GetParameterHistoryInput,
+	_ ...func(*ssm.Options),
+) (*ssm.GetParameterHistoryOutput, error) {
+	s.call("GetParameterHistory")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := aws.ToString(params.Name)
+	p, ok := s.parameters[name]
+	if !ok {
+		return nil, &types.ParameterNotFound{
+			Message: aws.String("Parameter " + name + " not found."),
+		}
+	}
+
+	hs := make([]types.ParameterHistory, 0, len(p.history))
+	for _, v := range p.history {
+		hs = append(hs, types.ParameterHistory{
+			DataType:         aws.String(v.dataType),
+			Description:      aws.String(v.description),
+			KeyId:            v.keyID(),
+			Labels:           slices.Clone(v.labels),
+			LastModifiedDate: aws.Time(v.modified),
+			LastModifiedUser: aws.String(modifiedUser),
+			Name:             aws.String(p.name),
+			Tier:             types.ParameterTierStandard,
+			Type:             v.typ,
+			Value:            aws.String(v.value),
+			Version:          v.version,
+		})
+	}
+
+	page, next, ok := paginate(hs, params.NextToken, params.MaxResults, 50)
+	if !ok {
+		return nil, &types.InvalidNextToken{
+			Message: aws.String("The NextToken value is invalid"),
+		}
+	}
+
+	return &ssm.GetParameterHistoryOutput{
+		NextToken:  next,
+		Parameters: page,
+	}, nil
+}
+
+func (s *SSM) GetParameters(_ context.Context, params *ssm.GetParametersInput,
+	_ ...func(*ssm.Options),
+) (*ssm.GetParametersOutput, error) {
+	s.call("GetParameters")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(params.Names) == 0 || len(params.Names) > 10 {
+		return nil, &types.ValidationException{
+			Message: aws.String("Names must contain between 1 and 10 parameter names"),
+		}
+	}
+
+	out := &ssm.GetParametersOutput{
+		InvalidParameters: make([]string, 0),
+		Parameters:        make([]types.Parameter, 0, len(params.Names)),
+	}
+
+	for _, n := range params.Names {
+		name, sel, hasSel := strings.Cut(n, ":")
+		p, ok := s.parameters[name]
+		if !ok {
+			out.InvalidParameters = append(out.InvalidParameters, n)
+			continue
+		}
+
+		v := p.latest()
+		if hasSel {
+			v = p.selected(sel)
+			if v == nil {
+				out.InvalidParameters = append(out.InvalidParameters, n)
+				continue
+			}
+		}
+
+		o := p.output(v)
+		if hasSel {
+			o.Selector = aws.String(":" + sel)
+		}
+		out.Parameters = append(out.Parameters, o)
+	}
+
+	return out, nil
+}
+
+// selected returns the version of a "name:version" or "name:label" selector
+func (p *parameter) selected(sel string) *parameterVersion {
+	if n, err := strconv.ParseInt(sel, 10, 64); err == nil {
+		if n < 1 || n > int64(len(p.history)) {
+			return nil
+		}
+		return p.history[n-1]
+	}
+
+	return p.labeled([]string{sel})
+}
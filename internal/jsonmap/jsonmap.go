@@ -0,0 +1,54 @@
+// Package jsonmap holds helpers for JSON documents decoded into map[string]any
+package jsonmap
+
+// Merge deep-merges src into dst and returns dst,
+// values in src take priority, nested objects are merged key by key
+func Merge(dst, src map[string]any) map[string]any {
+	if dst == nil {
+		dst = make(map[string]any, len(src))
+	}
+
+	for k, sv := range src {
+		sm, sok := sv.(map[string]any)
+		dm, dok := dst[k].(map[string]any)
+		if sok && dok {
+			dst[k] = Merge(dm, sm)
+			continue
+		}
+
+		if sok {
+			// Copy, so later merges never write into src
+			dst[k] = Merge(nil, sm)
+			continue
+		}
+
+		dst[k] = sv
+	}
+
+	return dst
+}
+
+// Flatten returns the leaf values of the document keyed by dotted paths,
+// e.g. {"db":{"host":"h"}} is flattened to {"db.host":"h"}, arrays are leaf values
+func Flatten(m map[string]any) map[string]any {
+	out := make(map[string]any)
+	flatten(out, "", m)
+
+	return out
+}
+
+func flatten(out map[string]any, prefix string, m map[string]any) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		if sub, ok := v.(map[string]any); ok && len(sub) > 0 {
+			flatten(out, key, sub)
+			continue
+		}
+
+		out[key] = v
+	}
+}
@@ -0,0 +1,51 @@
+package jsonmap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	common := map[string]any{
+		"log": map[string]any{"level": "info", "format": "json"},
+		"db":  map[string]any{"host": "common", "port": 5432.0},
+	}
+	app := map[string]any{
+		"log":  map[string]any{"level": "debug"},
+		"db":   "disabled",
+		"name": "app",
+	}
+
+	got := Merge(Merge(nil, common), app)
+	want := map[string]any{
+		"log":  map[string]any{"level": "debug", "format": "json"},
+		"db":   "disabled",
+		"name": "app",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged document mismatch\nactual:  %v\nexpected: %v", got, want)
+	}
+
+	if common["log"].(map[string]any)["level"] != "info" {
+		t.Error("source document was modified by merge")
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	got := Flatten(map[string]any{
+		"db":    map[string]any{"host": "h", "opts": map[string]any{"ssl": true}},
+		"hosts": []any{"a", "b"},
+		"empty": map[string]any{},
+	})
+	want := map[string]any{
+		"db.host":     "h",
+		"db.opts.ssl": true,
+		"hosts":       []any{"a", "b"},
+		"empty":       map[string]any{},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("flattened document mismatch\nactual:  %v\nexpected: %v", got, want)
+	}
+}
@@ -0,0 +1,55 @@
+// Package testutil holds the helpers shared by the tests of the config providers
+package testutil
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/litsea/viper-aws/cache"
+)
+
+// ReadAll reads the reader returned with err by a provider Get
+func ReadAll(t testing.TB, r io.Reader, err error) string {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(bs)
+}
+
+// Receive waits for the next value sent by a provider watcher
+func Receive(t testing.TB, ch <-chan *viper.RemoteResponse) string {
+	t.Helper()
+
+	select {
+	case resp := <-ch:
+		return string(resp.Value)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no change received from watcher")
+		return ""
+	}
+}
+
+// NewCache returns a cache of the file with a fixed test key,
+// caches of the same file share the saved value
+func NewCache(t testing.TB, path string) *cache.Cache {
+	t.Helper()
+
+	c, err := cache.New(path, bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
@@ -0,0 +1,108 @@
+package parameterstore
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// DecodeOption configures DecodeWithOptions
+type DecodeOption func(c *mapstructure.DecoderConfig)
+
+// DecodeWeaklyTyped converts the string values to the field types, e.g. "8080" to int,
+// enabled by default
+func DecodeWeaklyTyped(w bool) DecodeOption {
+	return func(c *mapstructure.DecoderConfig) {
+		c.WeaklyTypedInput = w
+	}
+}
+
+// DecodeHooks runs the hooks before the standard hooks of DecodeWithOptions
+func DecodeHooks(hooks ...mapstructure.DecodeHookFunc) DecodeOption {
+	return func(c *mapstructure.DecoderConfig) {
+		c.DecodeHook = mapstructure.ComposeDecodeHookFunc(append(hooks, c.DecodeHook)...)
+	}
+}
+
+// DecodeTagName sets the struct tag of the field names, defaults to mapstructure
+func DecodeTagName(tag string) DecodeOption {
+	return func(c *mapstructure.DecoderConfig) {
+		c.TagName = tag
+	}
+}
+
+// DecodeErrorUnused fails when a parameter has no matching field
+func DecodeErrorUnused(e bool) DecodeOption {
+	return func(c *mapstructure.DecoderConfig) {
+		c.ErrorUnused = e
+	}
+}
+
+// DecodeErrorUnset fails when a field has no matching parameter
+func DecodeErrorUnset(e bool) DecodeOption {
+	return func(c *mapstructure.DecoderConfig) {
+		c.ErrorUnset = e
+	}
+}
+
+// DecodeWithOptions decodes the parameters into the output, path segments are nested structs,
+// e.g. db/host is decoded into DB.Host.
+// The standard hooks decode time.Duration, time.Time (RFC 3339), comma separated slices,
+// net.IP, net.IPNet, url.URL and encoding.TextUnmarshaler fields.
+func (ps *Parameters) DecodeWithOptions(output any, opts ...DecodeOption) error {
+	doc, err := ps.buildDocument(true)
+	if err != nil {
+		return err
+	}
+
+	c := &mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToTimeHookFunc(time.RFC3339),
+			mapstructure.StringToSliceHookFunc(","),
+			mapstructure.StringToIPHookFunc(),
+			mapstructure.StringToIPNetHookFunc(),
+			mapstructure.StringToURLHookFunc(),
+			stringToURLHookFunc(),
+			mapstructure.TextUnmarshallerHookFunc(),
+		),
+		WeaklyTypedInput: true,
+		Result:           output,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	d, err := mapstructure.NewDecoder(c)
+	if err != nil {
+		return fmt.Errorf("viperaws.parameterstore.Parameters.DecodeWithOptions: %w", err)
+	}
+
+	err = d.Decode(doc)
+	if err != nil {
+		return fmt.Errorf("viperaws.parameterstore.Parameters.DecodeWithOptions: %w", err)
+	}
+
+	return nil
+}
+
+// stringToURLHookFunc decodes strings to url.URL values, mapstructure only decodes *url.URL
+func stringToURLHookFunc() mapstructure.DecodeHookFuncType {
+	return func(f reflect.Type, t reflect.Type, data any) (any, error) {
+		s, ok := data.(string)
+		if !ok || f.Kind() != reflect.String || t != reflect.TypeFor[url.URL]() {
+			return data, nil
+		}
+
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("parse url %s, %w", s, err)
+		}
+
+		return *u, nil
+	}
+}
@@ -0,0 +1,194 @@
+package parameterstore
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// describedParameter is the metadata of a parameter and the base path it comes from
+type describedParameter struct {
+	md       types.ParameterMetadata
+	basePath string
+}
+
+// describeCheckable reports whether the metadata of DescribeParameters shows every change,
+// moving a label changes neither the version nor the last modified date of a parameter
+func (p *Provider) describeCheckable() bool {
+	if !p.describeCheck || p.label != "" || len(p.selectors) > 0 || p.historyLabels {
+		return false
+	}
+
+	for _, f := range p.filters {
+		if aws.ToString(f.Key) == "Label" {
+			return false
+		}
+	}
+
+	for _, name := range p.names {
+		if strings.Contains(name, ":") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// watchResult Get the parameters for the watcher, with WithDescribeCheck only the created
+// and updated parameters are fetched and patched into the previous snapshot
+func (p *Provider) watchResult(prev *Parameters) (*Parameters, error) {
+	if prev == nil || !p.describeCheckable() {
+		return p.getResult(prev)
+	}
+
+	described, complete, err := p.describeCurrent()
+	if err != nil {
+		return nil, err
+	}
+
+	// Let GetResult report missing names and empty base paths
+	if !complete || len(described) == 0 {
+		return p.getResult(prev)
+	}
+
+	ps := make(map[string]*Parameter, len(described))
+	fetch := make(map[string][]string)
+
+	for k, d := range described {
+		pp, ok := prev.parameters[k]
+		if ok && pp.Key == *d.md.Name && pp.Version == d.md.Version &&
+			pp.LastModifiedDate.Equal(aws.ToTime(d.md.LastModifiedDate)) {
+			ps[k] = pp.clone()
+			continue
+		}
+
+		fetch[*d.md.Name] = append(fetch[*d.md.Name], k)
+	}
+
+	fetched, complete, err := p.fetchChanged(fetch, described)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deleted between DescribeParameters and GetParameters
+	if !complete {
+		return p.getResult(prev)
+	}
+
+	maps.Copy(ps, fetched)
+
+	err = p.resolveSecrets(ps)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.expandJSON(fetched, prev)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := p.newResult(ps)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.parameterstore.Provider.watchResult: %w", err)
+	}
+
+	return result, nil
+}
+
+// describeCurrent pages the metadata of the parameters, keyed like GetResult,
+// complete is false when a name of WithNames is not found
+func (p *Provider) describeCurrent() (map[string]describedParameter, bool, error) {
+	described := make(map[string]describedParameter)
+
+	if len(p.names) > 0 {
+		// Type and label filters do not apply to names
+		filters := p.tagFilters
+		names := slices.Sorted(maps.Values(p.names))
+		names = slices.Compact(names)
+
+		byName := make(map[string]types.ParameterMetadata, len(names))
+		for _, scope := range nameScopes(names) {
+			mds, err := p.describeParameters(scope, filters)
+			if err != nil {
+				return nil, false, err
+			}
+
+			for _, md := range mds {
+				byName[*md.Name] = md
+			}
+		}
+
+		for k, name := range p.names {
+			md, ok := byName[name]
+			if !ok {
+				if len(p.tagFilters) == 0 {
+					return nil, false, nil
+				}
+				continue
+			}
+
+			described[k] = describedParameter{md: md}
+		}
+
+		return described, true, nil
+	}
+
+	// Later base paths override earlier ones
+	filters := slices.Concat(p.filters, p.tagFilters)
+	for _, bp := range p.basePaths {
+		mds, err := p.describeParameters(p.pathScope(bp), filters)
+		if err != nil {
+			return nil, false, err
+		}
+
+		for _, md := range mds {
+			described[strings.TrimPrefix(*md.Name, bp)] = describedParameter{md: md, basePath: bp}
+		}
+	}
+
+	return described, true, nil
+}
+
+// fetchChanged Get the values of the created and updated parameters with GetParameters,
+// complete is false when a parameter is not found anymore
+func (p *Provider) fetchChanged(
+	fetch map[string][]string, described map[string]describedParameter,
+) (map[string]*Parameter, bool, error) {
+	ps := make(map[string]*Parameter, len(fetch))
+	names := slices.Sorted(maps.Keys(fetch))
+
+	// Maximum 10 names per call
+	for chunk := range slices.Chunk(names, 10) {
+		result, err := p.clt.GetParameters(context.Background(), &ssm.GetParametersInput{
+			Names:          chunk,
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("viperaws.parameterstore.Provider.fetchChanged: GetParameters %s, %w",
+				p.basePath, err)
+		}
+
+		if len(result.InvalidParameters) > 0 {
+			return nil, false, nil
+		}
+
+		for _, v := range result.Parameters {
+			for _, k := range fetch[aws.ToString(v.Name)] {
+				pp := newParameter(v)
+				pp.BasePath = described[k].basePath
+				if p.metadata {
+					pp.setMetadata(described[k].md)
+				}
+				ps[k] = pp
+			}
+		}
+	}
+
+	return ps, true, nil
+}
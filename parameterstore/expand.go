@@ -0,0 +1,61 @@
+package parameterstore
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// expandJSON marks the parameters of WithJSONValues, WithJSONTag and WithJSONDataTypes
+// to be expanded, invalid JSON values are kept as strings and logged once per version since prev
+func (p *Provider) expandJSON(ps map[string]*Parameter, prev *Parameters) error {
+	if !p.jsonValues && len(p.jsonTags) == 0 && len(p.jsonDataTypes) == 0 {
+		return nil
+	}
+
+	tagged := make(map[string]bool)
+	if len(p.jsonTags) > 0 {
+		for _, scope := range p.describeScopes(ps) {
+			names, err := p.taggedNames(scope, p.jsonTags)
+			if err != nil {
+				return err
+			}
+
+			maps.Copy(tagged, names)
+		}
+	}
+
+	for k, pp := range ps {
+		if pp.Type == types.ParameterTypeStringList || pp.secret != nil {
+			continue
+		}
+
+		v := strings.TrimSpace(pp.GetValue())
+		declared := tagged[pp.Key] || slices.Contains(p.jsonDataTypes, pp.DataType)
+		if !declared && (!p.jsonValues || !isJSONContainer(v)) {
+			continue
+		}
+
+		if !json.Valid([]byte(v)) {
+			if prev.sameVersion(k, pp) != nil {
+				continue
+			}
+
+			p.l.Warn("viperaws.parameterstore.Provider.expandJSON: invalid JSON value, kept as string",
+				"key", k, "name", pp.Key)
+			continue
+		}
+
+		pp.expand = true
+	}
+
+	return nil
+}
+
+// isJSONContainer reports whether the value looks like a JSON object or array
+func isJSONContainer(v string) bool {
+	return strings.HasPrefix(v, "{") || strings.HasPrefix(v, "[")
+}
@@ -0,0 +1,129 @@
+package parameterstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+var ErrAwsSSMNamesAndBasePaths = errors.New("AWS SSM parameters accepts either names or base paths, not both")
+
+// InvalidParametersError reports the names of WithNames GetParameters did not find
+type InvalidParametersError struct {
+	// Keys are the viper keys of the invalid parameters, sorted
+	Keys []string
+	// Names are the parameter names of the keys, including the selector
+	Names []string
+}
+
+func (e *InvalidParametersError) Error() string {
+	msgs := make([]string, 0, len(e.Keys))
+	for i, k := range e.Keys {
+		msgs = append(msgs, k+": "+e.Names[i])
+	}
+
+	return "AWS SSM invalid parameters: " + strings.Join(msgs, "; ")
+}
+
+// getByNames Get the parameters of WithNames with GetParameters, keyed by the viper key
+func (p *Provider) getByNames() (map[string]*Parameter, error) {
+	keysByName := make(map[string][]string, len(p.names))
+	for _, k := range slices.Sorted(maps.Keys(p.names)) {
+		keysByName[p.names[k]] = append(keysByName[p.names[k]], k)
+	}
+
+	names := slices.Sorted(maps.Keys(keysByName))
+	ps := make(map[string]*Parameter, len(p.names))
+	invalid := &InvalidParametersError{}
+
+	// Maximum 10 names per call
+	for chunk := range slices.Chunk(names, 10) {
+		result, err := p.clt.GetParameters(context.Background(), &ssm.GetParametersInput{
+			Names:          chunk,
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("viperaws.parameterstore.Provider.getByNames: GetParameters %s, %w",
+				strings.Join(chunk, ","), err)
+		}
+
+		for _, v := range result.Parameters {
+			pp := newParameter(v)
+			for _, k := range keysByName[pp.Key+pp.Selector] {
+				cp := *pp
+				ps[k] = &cp
+			}
+		}
+
+		for _, name := range result.InvalidParameters {
+			for _, k := range keysByName[name] {
+				invalid.Keys = append(invalid.Keys, k)
+				invalid.Names = append(invalid.Names, name)
+			}
+		}
+	}
+
+	if len(invalid.Keys) > 0 {
+		return nil, fmt.Errorf("viperaws.parameterstore.Provider.getByNames: %w", invalid)
+	}
+
+	return p.filterTagged(ps)
+}
+
+// filterTagged drops the parameters of WithNames not matching the tag filters
+func (p *Provider) filterTagged(ps map[string]*Parameter) (map[string]*Parameter, error) {
+	if len(p.tagFilters) == 0 {
+		return ps, nil
+	}
+
+	tagged := make(map[string]bool, len(ps))
+	for _, scope := range nameScopes(parameterNames(ps)) {
+		names, err := p.taggedNames(scope, p.tagFilters)
+		if err != nil {
+			return nil, err
+		}
+
+		maps.Copy(tagged, names)
+	}
+
+	maps.DeleteFunc(ps, func(_ string, v *Parameter) bool {
+		return !tagged[v.Key]
+	})
+
+	return ps, nil
+}
+
+// parameterNames returns the sorted unique names of the parameters
+func parameterNames(ps map[string]*Parameter) []string {
+	names := make([]string, 0, len(ps))
+	for _, v := range ps {
+		if !slices.Contains(names, v.Key) {
+			names = append(names, v.Key)
+		}
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// nameScopes returns the Name filters limiting DescribeParameters to the names
+func nameScopes(names []string) []types.ParameterStringFilter {
+	// Maximum 50 values per filter
+	scopes := make([]types.ParameterStringFilter, 0, len(names)/50+1)
+	for chunk := range slices.Chunk(names, 50) {
+		scopes = append(scopes, types.ParameterStringFilter{
+			Key:    aws.String("Name"),
+			Option: aws.String("Equals"),
+			Values: chunk,
+		})
+	}
+
+	return scopes
+}
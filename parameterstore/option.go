@@ -3,7 +3,12 @@ package parameterstore
 import (
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+
+	"github.com/litsea/viper-aws/cache"
 	"github.com/litsea/viper-aws/log"
+	"github.com/litsea/viper-aws/watch"
 )
 
 type Option func(p *Provider)
@@ -14,19 +19,276 @@ func WithBasePath(bp string) Option {
 			return
 		}
 
-		if bp[len(bp)-1] != '/' {
-			bp += "/"
+		p.basePaths = []string{normalizeBasePath(bp)}
+	}
+}
+
+// WithBasePaths reads multiple base paths in order, later base paths override earlier ones,
+// e.g. /common/, /app-a/common/, /app-a/prod/
+func WithBasePaths(bps ...string) Option {
+	return func(p *Provider) {
+		if len(bps) == 0 {
+			return
+		}
+
+		p.basePaths = make([]string, 0, len(bps))
+		for _, bp := range bps {
+			if bp != "" {
+				p.basePaths = append(p.basePaths, normalizeBasePath(bp))
+			}
+		}
+	}
+}
+
+func normalizeBasePath(bp string) string {
+	if bp[len(bp)-1] != '/' {
+		bp += "/"
+	}
+
+	return bp
+}
+
+// WithNames reads the parameters by name with GetParameters instead of scanning base paths,
+// keyed by the viper key, e.g. {"db.host": "/shared/db/host", "api.token": "/app-a/token:stable"}.
+// Type and label filters do not apply to names.
+func WithNames(names map[string]string) Option {
+	return func(p *Provider) {
+		p.names = names
+	}
+}
+
+// WithClient uses the given client instead of creating one from the AWS config
+func WithClient(c Client) Option {
+	return func(p *Provider) {
+		p.clt = c
+	}
+}
+
+// WithAWSConfig builds the client from the given AWS config instead of LoadDefaultConfig,
+// retryers, HTTP clients and middlewares of the config are kept
+func WithAWSConfig(cfg aws.Config) Option {
+	return func(p *Provider) {
+		p.awsCfg = &cfg
+	}
+}
+
+// WithProfile loads the AWS config with the shared config profile
+func WithProfile(name string) Option {
+	return func(p *Provider) {
+		p.profile = name
+	}
+}
+
+// AssumeRole is the IAM role assumed with STS, e.g. to read config of another account
+type AssumeRole struct {
+	RoleARN     string
+	ExternalID  string
+	SessionName string
+	// Duration of the role session, defaults to 15 minutes
+	Duration time.Duration
+}
+
+// WithAssumeRole assumes the role with the configured credentials,
+// the temporary credentials are refreshed automatically before they expire
+func WithAssumeRole(ar AssumeRole) Option {
+	return func(p *Provider) {
+		p.assumeRole = &ar
+	}
+}
+
+// WithRecursive reads all parameters below the base path, not only its direct children
+func WithRecursive(r bool) Option {
+	return func(p *Provider) {
+		p.recursive = r
+	}
+}
+
+// WithParameterTypes only reads parameters of the types, e.g. only SecureString
+func WithParameterTypes(ts ...types.ParameterType) Option {
+	return func(p *Provider) {
+		if len(ts) == 0 {
+			return
+		}
+
+		vs := make([]string, 0, len(ts))
+		for _, t := range ts {
+			vs = append(vs, string(t))
+		}
+
+		p.filters = append(p.filters, types.ParameterStringFilter{
+			Key:    aws.String("Type"),
+			Option: aws.String("Equals"),
+			Values: vs,
+		})
+	}
+}
+
+// WithLabelFilter only reads parameters having a version labeled with one of the labels,
+// the labeled version is read and parameters without the labels are left out.
+// Use WithLabel to read the labeled versions and keep the other parameters.
+func WithLabelFilter(labels ...string) Option {
+	return func(p *Provider) {
+		if len(labels) == 0 {
+			return
+		}
+
+		p.filters = append(p.filters, types.ParameterStringFilter{
+			Key:    aws.String("Label"),
+			Option: aws.String("Equals"),
+			Values: labels,
+		})
+	}
+}
+
+// WithTagFilter only reads parameters tagged with the key and one of the values,
+// any value of the tag matches when no value is given
+func WithTagFilter(key string, values ...string) Option {
+	return func(p *Provider) {
+		f := types.ParameterStringFilter{
+			Key:    aws.String("tag:" + key),
+			Values: values,
+		}
+		if len(values) > 0 {
+			f.Option = aws.String("Equals")
+		}
+
+		p.tagFilters = append(p.tagFilters, f)
+	}
+}
+
+// WithLabel reads the versions of the parameters labeled with the label, e.g. stable,
+// parameters without the label fall back to the latest version.
+// Unlike WithLabelFilter, no parameter under the base paths is left out.
+// Moving the label to another version is a change for the watcher.
+func WithLabel(label string) Option {
+	return func(p *Provider) {
+		p.label = label
+	}
+}
+
+// WithSelectors reads specific versions of parameters, keyed by the name under the base path,
+// a selector is a label or a version number, e.g. {"db/host": "3", "api/token": "stable"}.
+// A selector takes precedence over WithLabel.
+func WithSelectors(selectors map[string]string) Option {
+	return func(p *Provider) {
+		p.selectors = selectors
+	}
+}
+
+// WithMetadata sets the tier, policies, description, KMS key ID and LastModifiedUser
+// of the parameters with DescribeParameters, one more scan of the base paths per Get, 50 parameters a page.
+// The watcher only describes the created and updated parameters,
+// the others keep the metadata of the previous read.
+func WithMetadata(m bool) Option {
+	return func(p *Provider) {
+		p.metadata = m
+	}
+}
+
+// WithHistoryLabels sets the labels of the parameter versions with GetParameterHistory,
+// the history of every parameter is paged from the oldest version on Get,
+// mind the low throughput limit of GetParameterHistory with many parameters.
+// The watcher only reads the history of the created and updated parameters,
+// a label moved to an unchanged version is seen on the next Get.
+func WithHistoryLabels(h bool) Option {
+	return func(p *Provider) {
+		p.historyLabels = h
+	}
+}
+
+// WithNestedPaths reads the base path recursively and makes path segments nested objects,
+// e.g. /app/prod/db/host is read as db.host instead of "db/host"
+func WithNestedPaths(n bool) Option {
+	return func(p *Provider) {
+		p.nestedPaths = n
+	}
+}
+
+// WithJSONValues expands every value that is a JSON object or array into a sub-tree of the document,
+// e.g. /app/prod/redis = {"host": "...", "port": 6379} is read as redis.host and redis.port.
+// With WithNestedPaths, a parameter below an expanded value, e.g. /app/prod/redis/db, is a path conflict.
+func WithJSONValues(j bool) Option {
+	return func(p *Provider) {
+		p.jsonValues = j
+	}
+}
+
+// WithJSONTag expands the JSON values of the parameters tagged with the key and one of the values,
+// any value of the tag matches when no value is given
+func WithJSONTag(key string, values ...string) Option {
+	return func(p *Provider) {
+		f := types.ParameterStringFilter{
+			Key:    aws.String("tag:" + key),
+			Values: values,
 		}
-		p.basePath = bp
+		if len(values) > 0 {
+			f.Option = aws.String("Equals")
+		}
+
+		p.jsonTags = append(p.jsonTags, f)
+	}
+}
+
+// WithJSONDataTypes expands the JSON values of the parameters of the data types
+func WithJSONDataTypes(dts ...string) Option {
+	return func(p *Provider) {
+		p.jsonDataTypes = append(p.jsonDataTypes, dts...)
 	}
 }
 
+// WithSecretReferences resolves the parameters whose value is a secret reference,
+// e.g. secretsmanager:app/prod/db splices the JSON of the secret and
+// secretsmanager:app/prod/db#password the value of its key.
+// The secret is fetched again when its AWSCURRENT version changes.
+func WithSecretReferences(r bool) Option {
+	return func(p *Provider) {
+		p.secretRefs = r
+	}
+}
+
+// WithSecretsClient resolves the secret references with the given client, implies WithSecretReferences
+func WithSecretsClient(c SecretsClient) Option {
+	return func(p *Provider) {
+		p.secretRefs = true
+		p.secretsClt = c
+	}
+}
+
+// WithDescribeCheck makes the watcher page DescribeParameters on every tick, without decryption,
+// and only fetch the values of the created and updated parameters with GetParameters.
+// The watcher reads every value when labels or selectors are used,
+// moving a label is not visible in the metadata.
+// Requires ssm:DescribeParameters and ssm:GetParameters.
+func WithDescribeCheck(d bool) Option {
+	return func(p *Provider) {
+		p.describeCheck = d
+	}
+}
+
+// WithRegion sets the region, it takes precedence over AWS_REGION,
+// defaults to the region of the AWS config or us-east-1
 func WithRegion(r string) Option {
 	return func(p *Provider) {
 		p.region = r
 	}
 }
 
+// WithEndpoint overrides the SSM endpoint URL,
+// e.g. LocalStack, a VPC endpoint or a fake.NewServer
+func WithEndpoint(url string) Option {
+	return func(p *Provider) {
+		p.endpoint = url
+	}
+}
+
+// WithSecretsEndpoint overrides the Secrets Manager endpoint URL of the secret references,
+// the SSM endpoint of WithEndpoint is not used for Secrets Manager
+func WithSecretsEndpoint(url string) Option {
+	return func(p *Provider) {
+		p.secretsEndpoint = url
+	}
+}
+
 func WithAccessKey(ak string) Option {
 	return func(p *Provider) {
 		p.accessKey = ak
@@ -53,6 +315,30 @@ func WithWatchInterval(w time.Duration) Option {
 	}
 }
 
+// WithWatchStrategy sets the polling strategy of the watcher, defaults to watch.DefaultStrategy,
+// e.g. watch.Strategy{} polls at the fixed watch interval
+func WithWatchStrategy(s watch.Strategy) Option {
+	return func(p *Provider) {
+		p.watchStrategy = s
+	}
+}
+
+// WithOnWatchStopFunc is called when the watcher stops on an error, see watch.Policy
+func WithOnWatchStopFunc(fn func(err *watch.Error)) Option {
+	return func(p *Provider) {
+		p.onWatchStopFunc = fn
+	}
+}
+
+// WithCache saves every fetched value to the cache and reads the last saved value
+// when a read fails, e.g. AWS is unreachable at startup, the watcher refreshes it.
+// A value saved by a provider of another name, e.g. after the base path changed, is not read.
+func WithCache(c *cache.Cache) Option {
+	return func(p *Provider) {
+		p.cache = c
+	}
+}
+
 func WithLogger(l log.Logger) Option {
 	return func(p *Provider) {
 		if l != nil {
@@ -1,26 +1,75 @@
 package parameterstore
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
 	"github.com/go-viper/mapstructure/v2"
 )
 
+var ErrAwsSSMParameterPathConflict = errors.New("AWS SSM parameter is both a value and a path")
+
+// Parameters is an immutable snapshot of the parameters, safe for concurrent use
 type Parameters struct {
-	readIndex  int64
-	bytesJSON  []byte
 	basePath   string
+	nested     bool
+	delimiter  string
 	parameters map[string]*Parameter
+
+	// The JSON document is computed once
+	once      sync.Once
+	bytesJSON []byte
+	err       error
+
+	mu        sync.Mutex
+	readIndex int64
 }
 
 type Parameter struct {
 	Key              string
 	Value            *string
+	Type             types.ParameterType
+	DataType         string
+	ARN              string
+	Selector         string
+	SourceResult     string
 	Version          int64
 	LastModifiedDate time.Time
+
+	// BasePath is the base path the value comes from
+	BasePath string
+
+	// Labels of the version, only set with WithHistoryLabels
+	Labels []string
+
+	// Only set with WithMetadata
+	Tier             types.ParameterTier
+	Policies         []types.ParameterInlinePolicy
+	Description      string
+	KeyID            string
+	LastModifiedUser string
+	AllowedPattern   string
+
+	// SecretID and SecretVersionID are the secret referenced by the value, see WithSecretReferences
+	SecretID        string
+	SecretVersionID string
+
+	// secret and secretKey are the referenced secret spliced into the document
+	secret    *secretValue
+	secretKey string
+
+	// expand is set when the JSON value is expanded into a sub-tree of the document
+	expand bool
 }
 
 func (p *Parameter) GetValue() string {
@@ -30,33 +79,177 @@ func (p *Parameter) GetValue() string {
 	return *p.Value
 }
 
+// GetValues returns the comma-separated values of a StringList parameter,
+// other types return the value as the only element
+func (p *Parameter) GetValues() []string {
+	if p.Type != types.ParameterTypeStringList {
+		return []string{p.GetValue()}
+	}
+
+	if p.GetValue() == "" {
+		return []string{}
+	}
+
+	return strings.Split(p.GetValue(), ",")
+}
+
+// clone returns a deep copy of the parameter, changing it does not change the snapshot
+func (p *Parameter) clone() *Parameter {
+	cp := *p
+	cp.Labels = slices.Clone(p.Labels)
+	cp.Policies = slices.Clone(p.Policies)
+
+	if p.Value != nil {
+		cp.Value = aws.String(*p.Value)
+	}
+
+	if p.secret != nil {
+		sv := *p.secret
+		cp.secret = &sv
+	}
+
+	return &cp
+}
+
+// setMetadata sets the metadata of WithMetadata
+func (p *Parameter) setMetadata(md types.ParameterMetadata) {
+	p.Tier = md.Tier
+	p.Policies = md.Policies
+	p.Description = aws.ToString(md.Description)
+	p.KeyID = aws.ToString(md.KeyId)
+	p.LastModifiedUser = aws.ToString(md.LastModifiedUser)
+	p.AllowedPattern = aws.ToString(md.AllowedPattern)
+}
+
+// copyMetadata copies the metadata of WithMetadata and WithHistoryLabels from the same version
+func (p *Parameter) copyMetadata(from *Parameter) {
+	p.Labels = slices.Clone(from.Labels)
+	p.Tier = from.Tier
+	p.Policies = slices.Clone(from.Policies)
+	p.Description = from.Description
+	p.KeyID = from.KeyID
+	p.LastModifiedUser = from.LastModifiedUser
+	p.AllowedPattern = from.AllowedPattern
+}
+
+// documentValue returns the value in the document fed to viper,
+// StringList parameters become arrays
+func (p *Parameter) documentValue() any {
+	if p.secret != nil {
+		if v, err := secretDocumentValue(p.secret.value, p.secretKey); err == nil {
+			return v
+		}
+	}
+
+	if p.expand {
+		var v any
+		if err := json.Unmarshal([]byte(p.GetValue()), &v); err == nil {
+			return v
+		}
+	}
+
+	if p.Type == types.ParameterTypeStringList {
+		return p.GetValues()
+	}
+
+	return p.GetValue()
+}
+
 func NewParameters(bp string, parameters map[string]*Parameter) *Parameters {
+	return newParameters(bp, parameters, false, "/")
+}
+
+func newParameters(bp string, parameters map[string]*Parameter, nested bool, delimiter string) *Parameters {
+	// Copies, the caller cannot change the snapshot through its parameters
+	ps := make(map[string]*Parameter, len(parameters))
+	for k, v := range parameters {
+		ps[k] = v.clone()
+	}
+
 	return &Parameters{
 		basePath:   bp,
-		parameters: parameters,
+		nested:     nested,
+		delimiter:  delimiter,
+		parameters: ps,
 	}
 }
 
-func (ps *Parameters) Read(des []byte) (n int, err error) {
-	if ps.bytesJSON == nil {
-		ps.bytesJSON, err = json.Marshal(ps.getKeyValueMap())
+// load computes the JSON document once
+func (ps *Parameters) load() ([]byte, error) {
+	ps.once.Do(func() {
+		doc, err := ps.document()
 		if err != nil {
-			return 0, err
+			ps.err = err
+			return
 		}
+
+		ps.bytesJSON, ps.err = json.Marshal(doc)
+	})
+
+	return ps.bytesJSON, ps.err
+}
+
+// JSON returns a copy of the JSON document fed to viper
+func (ps *Parameters) JSON() ([]byte, error) {
+	bs, err := ps.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.Clone(bs), nil
+}
+
+// NewReader returns a new reader of the JSON document, each reader has its own position
+func (ps *Parameters) NewReader() (*bytes.Reader, error) {
+	bs, err := ps.load()
+	if err != nil {
+		return nil, err
 	}
 
-	if ps.readIndex >= int64(len(ps.bytesJSON)) {
+	return bytes.NewReader(bs), nil
+}
+
+// versions returns the versions of the parameters by name, with the version ID of a referenced secret
+func (ps *Parameters) versions() map[string]string {
+	vs := make(map[string]string, len(ps.parameters))
+	for _, v := range ps.parameters {
+		vs[v.Key] = strconv.FormatInt(v.Version, 10)
+		if v.SecretVersionID != "" {
+			vs[v.Key] += "/" + v.SecretVersionID
+		}
+	}
+
+	return vs
+}
+
+// Read reads the JSON document, rewinding at io.EOF.
+//
+// Deprecated: Read shares one position between all readers, use NewReader.
+func (ps *Parameters) Read(des []byte) (n int, err error) {
+	bs, err := ps.load()
+	if err != nil {
+		return 0, err
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.readIndex >= int64(len(bs)) {
 		ps.readIndex = 0
 		return 0, io.EOF
 	}
 
-	n = copy(des, ps.bytesJSON[ps.readIndex:])
+	n = copy(des, bs[ps.readIndex:])
 	ps.readIndex += int64(n)
 
 	return n, nil
 }
 
 func (ps *Parameters) GetFullPath(name string) string {
+	if parameter, ok := ps.parameters[name]; ok && parameter.Key != "" {
+		return parameter.Key
+	}
+
 	return ps.basePath + name
 }
 
@@ -64,18 +257,37 @@ func (ps *Parameters) GetByFullPath(p string) *Parameter {
 	name := strings.Replace(p, ps.basePath, "", 1)
 
 	parameter, ok := ps.parameters[name]
-	if !ok {
+	if ok && (parameter.Key == "" || parameter.Key == p) {
+		return parameter.clone()
+	}
+
+	// Parameters of a lower priority base path
+	for _, v := range ps.parameters {
+		if v.Key == p {
+			return v.clone()
+		}
+	}
+
+	return nil
+}
+
+// sameVersion returns the parameter of the key when its name and version are unchanged,
+// nil when ps is nil
+func (ps *Parameters) sameVersion(k string, pp *Parameter) *Parameter {
+	if ps == nil {
+		return nil
+	}
+
+	v, ok := ps.parameters[k]
+	if !ok || v.Key != pp.Key || v.Version != pp.Version || !v.LastModifiedDate.Equal(pp.LastModifiedDate) {
 		return nil
 	}
 
-	return parameter
+	return v
 }
 
 func (ps *Parameters) ExistsByFullPath(p string) bool {
-	name := strings.Replace(p, ps.basePath, "", 1)
-	_, ok := ps.parameters[name]
-
-	return ok
+	return ps.GetByFullPath(p) != nil
 }
 
 func (ps *Parameters) GetValueByFullPath(p string) string {
@@ -87,13 +299,14 @@ func (ps *Parameters) GetValueByFullPath(p string) string {
 	return parameter.GetValue()
 }
 
+// Get returns a copy of the parameter, the snapshot is not changed by changing it
 func (ps *Parameters) Get(name string) *Parameter {
 	parameter, ok := ps.parameters[name]
 	if !ok {
 		return nil
 	}
 
-	return parameter
+	return parameter.clone()
 }
 
 func (ps *Parameters) Exists(name string) bool {
@@ -111,14 +324,80 @@ func (ps *Parameters) GetValueByName(name string) string {
 }
 
 func (ps *Parameters) Decode(output any) error {
-	return mapstructure.Decode(ps.getKeyValueMap(), output)
+	doc, err := ps.document()
+	if err != nil {
+		return err
+	}
+
+	return mapstructure.Decode(doc, output)
+}
+
+// document returns the document fed to viper, path segments become nested objects
+// when the parameters are nested, e.g. db/host and db/port become {"db":{"host":..,"port":..}}
+func (ps *Parameters) document() (map[string]any, error) {
+	return ps.buildDocument(ps.nested)
+}
+
+// buildDocument builds the document, path segments are nested objects when nested is set
+func (ps *Parameters) buildDocument(nested bool) (map[string]any, error) {
+	doc := make(map[string]any, len(ps.parameters))
+	if !nested {
+		for k, v := range ps.parameters {
+			doc[k] = v.documentValue()
+		}
+
+		return doc, nil
+	}
+
+	// Sorted, so conflicts are reported the same way on every read
+	keys := make([]string, 0, len(ps.parameters))
+	for k := range ps.parameters {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	// The branches created for the paths, a path below the value of a parameter,
+	// e.g. an expanded JSON object, is a conflict
+	branches := make(map[string]bool)
+
+	for _, k := range keys {
+		segs := strings.Split(k, ps.delimiter)
+		m := doc
+		for i, seg := range segs[:len(segs)-1] {
+			branch := strings.Join(segs[:i+1], ps.delimiter)
+			next, ok := m[seg]
+			if !ok {
+				next = make(map[string]any)
+				m[seg] = next
+				branches[branch] = true
+			}
+
+			sub, ok := next.(map[string]any)
+			if !ok || !branches[branch] {
+				return nil, ps.conflictError(k, keys)
+			}
+			m = sub
+		}
+
+		leaf := segs[len(segs)-1]
+		if _, ok := m[leaf]; ok {
+			return nil, ps.conflictError(k, keys)
+		}
+		m[leaf] = ps.parameters[k].documentValue()
+	}
+
+	return doc, nil
 }
 
-func (ps *Parameters) getKeyValueMap() map[string]string {
-	kv := make(map[string]string, len(ps.parameters))
-	for k, v := range ps.parameters {
-		kv[k] = v.GetValue()
+// conflictError reports the parameter whose path is a parent or a child of the key,
+// e.g. /app/db and /app/db/host, or the key of an expanded JSON value
+func (ps *Parameters) conflictError(k string, keys []string) error {
+	for _, other := range keys {
+		if other != k && (strings.HasPrefix(k, other+ps.delimiter) || strings.HasPrefix(other, k+ps.delimiter)) {
+			return fmt.Errorf("%s and %s, %w", ps.GetFullPath(other), ps.GetFullPath(k),
+				ErrAwsSSMParameterPathConflict)
+		}
 	}
 
-	return kv
+	return fmt.Errorf("%s, %w", ps.GetFullPath(k), ErrAwsSSMParameterPathConflict)
 }
@@ -6,34 +6,87 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"maps"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/spf13/viper"
 
+	"github.com/litsea/viper-aws/cache"
 	"github.com/litsea/viper-aws/log"
+	"github.com/litsea/viper-aws/watch"
 )
 
+const defaultRegion = "us-east-1"
+
 var ErrAwsSSMParametersEmpty = errors.New("AWS SSM parameters is empty")
 
+// Client is the subset of the SSM API used by the provider,
+// it is implemented by *ssm.Client and fake.SSM
+type Client interface {
+	GetParametersByPath(ctx context.Context, params *ssm.GetParametersByPathInput,
+		optFns ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error)
+	DescribeParameters(ctx context.Context, params *ssm.DescribeParametersInput,
+		optFns ...func(*ssm.Options)) (*ssm.DescribeParametersOutput, error)
+	GetParameterHistory(ctx context.Context, params *ssm.GetParameterHistoryInput,
+		optFns ...func(*ssm.Options)) (*ssm.GetParameterHistoryOutput, error)
+	GetParameters(ctx context.Context, params *ssm.GetParametersInput,
+		optFns ...func(*ssm.Options)) (*ssm.GetParametersOutput, error)
+}
+
 // Provider implements reads configuration from AWS Parameter Store.
 type Provider struct {
-	clt           *ssm.Client
-	region        string
-	accessKey     string
-	secretKey     string
-	sessionToken  string
-	basePath      string // /<project>/<env>/
-	versions      map[string]int64
-	watchInterval time.Duration
-	quit          chan bool
-	l             log.Logger
-	onChangeFunc  func(ps *Parameters, changes *Changes)
+	clt             Client
+	region          string
+	accessKey       string
+	secretKey       string
+	sessionToken    string
+	endpoint        string
+	secretsEndpoint string
+	profile         string
+	awsCfg          *aws.Config
+	assumeRole      *AssumeRole
+	basePath        string // /<project>/<env>/, base paths joined with commas
+	basePaths       []string
+	names           map[string]string
+	jsonValues      bool
+	jsonTags        []types.ParameterStringFilter
+	jsonDataTypes   []string
+	secretRefs      bool
+	secretsClt      SecretsClient
+	secretsMu       sync.Mutex
+	secrets         map[string]secretValue
+	nestedPaths     bool
+	recursive       bool
+	filters         []types.ParameterStringFilter
+	tagFilters      []types.ParameterStringFilter
+	label           string
+	selectors       map[string]string
+	metadata        bool
+	historyLabels   bool
+	versionsMu      sync.Mutex
+	versions        map[string]parameterVersion
+	last            *Parameters
+	describeCheck   bool
+	watchInterval   time.Duration
+	watchStrategy   watch.Strategy
+	cache           *cache.Cache
+	watchMu         sync.Mutex
+	stopped         chan struct{}
+	onWatchStopFunc func(err *watch.Error)
+	quit            chan bool
+	l               log.Logger
+	onChangeFunc    func(ps *Parameters, changes *Changes)
 }
 
 type Changes struct {
@@ -41,14 +94,25 @@ type Changes struct {
 	Created []string
 	Updated []string
 	Deleted []string
+	// Layers maps the current keys to the base path their value comes from
+	Layers map[string]string
+}
+
+// parameterVersion is the full name and version a key was read from,
+// a key changes when it is overridden or falls back to another base path
+type parameterVersion struct {
+	name    string
+	version int64
+	// secretVersion is the version ID of the referenced secret
+	secretVersion string
 }
 
 // NewConfigProvider returns a new Provider.
 func NewConfigProvider(opts ...Option) (*Provider, error) {
 	p := &Provider{
-		region:        "us-east-1",
-		versions:      make(map[string]int64),
+		versions:      make(map[string]parameterVersion),
 		watchInterval: 5 * time.Second,
+		watchStrategy: watch.DefaultStrategy(),
 		quit:          make(chan bool),
 		l:             &log.EmptyLogger{},
 	}
@@ -57,31 +121,129 @@ func NewConfigProvider(opts ...Option) (*Provider, error) {
 		opt(p)
 	}
 
-	r := os.Getenv("AWS_REGION")
-	if r != "" {
-		p.region = r
+	if len(p.names) > 0 {
+		if len(p.basePaths) > 0 {
+			return nil, fmt.Errorf("viperaws.parameterstore.NewConfigProvider: %w", ErrAwsSSMNamesAndBasePaths)
+		}
+
+		p.basePath = strings.Join(slices.Sorted(maps.Values(p.names)), ",")
+	} else {
+		p.basePath = strings.Join(p.basePaths, ",")
+	}
+
+	err := p.loadClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *Provider) loadClient() error {
+	if p.clt != nil && (!p.secretRefs || p.secretsClt != nil) {
+		return nil
+	}
+
+	awsCfg, err := p.loadAWSConfig()
+	if err != nil {
+		return err
 	}
 
-	awsOpts := []func(*config.LoadOptions) error{
-		config.WithRegion(p.region),
+	// Create SSM client
+	if p.clt == nil {
+		p.clt = ssm.NewFromConfig(awsCfg, func(o *ssm.Options) {
+			if p.endpoint != "" {
+				o.BaseEndpoint = aws.String(p.endpoint)
+			}
+		})
+	}
+
+	// Create Secrets Manager client of the secret references
+	if p.secretRefs && p.secretsClt == nil {
+		p.secretsClt = secretsmanager.NewFromConfig(awsCfg, func(o *secretsmanager.Options) {
+			if p.secretsEndpoint != "" {
+				o.BaseEndpoint = aws.String(p.secretsEndpoint)
+			}
+		})
 	}
 
+	return nil
+}
+
+// loadAWSConfig returns the AWS config of the client,
+// an explicit region takes precedence over AWS_REGION and the shared config profile
+func (p *Provider) loadAWSConfig() (aws.Config, error) {
+	var cred aws.CredentialsProvider
 	if p.accessKey != "" && p.secretKey != "" {
-		cred := aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(
+		cred = aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(
 			p.accessKey, p.secretKey, p.sessionToken))
+	}
+
+	if p.awsCfg != nil {
+		awsCfg := p.awsCfg.Copy()
+		if p.region != "" {
+			awsCfg.Region = p.region
+		}
+		if cred != nil {
+			awsCfg.Credentials = cred
+		}
+		if awsCfg.Region == "" {
+			awsCfg.Region = defaultRegion
+		}
+
+		return p.assumeRoleConfig(awsCfg), nil
+	}
+
+	awsOpts := make([]func(*config.LoadOptions) error, 0)
+
+	if p.region != "" {
+		awsOpts = append(awsOpts, config.WithRegion(p.region))
+	}
+
+	if p.profile != "" {
+		awsOpts = append(awsOpts, config.WithSharedConfigProfile(p.profile))
+	}
+
+	if cred != nil {
 		awsOpts = append(awsOpts, config.WithCredentialsProvider(cred))
 	}
 
 	awsCfg, err := config.LoadDefaultConfig(context.Background(), awsOpts...)
 	if err != nil {
-		return nil, fmt.Errorf("viperaws.parameterstore.NewConfigProvider: LoadDefaultConfig %s, %w",
+		return aws.Config{}, fmt.Errorf("viperaws.parameterstore.NewConfigProvider: LoadDefaultConfig %s, %w",
 			p.basePath, err)
 	}
 
-	// Create Secrets Manager client
-	p.clt = ssm.NewFromConfig(awsCfg)
+	if awsCfg.Region == "" {
+		awsCfg.Region = defaultRegion
+	}
 
-	return p, nil
+	return p.assumeRoleConfig(awsCfg), nil
+}
+
+// assumeRoleConfig wraps the credentials of the AWS config in an auto-refreshing
+// assume role provider when WithAssumeRole is set
+func (p *Provider) assumeRoleConfig(awsCfg aws.Config) aws.Config {
+	if p.assumeRole == nil || p.assumeRole.RoleARN == "" {
+		return awsCfg
+	}
+
+	ar := p.assumeRole
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), ar.RoleARN,
+		func(o *stscreds.AssumeRoleOptions) {
+			if ar.ExternalID != "" {
+				o.ExternalID = aws.String(ar.ExternalID)
+			}
+			if ar.SessionName != "" {
+				o.RoleSessionName = ar.SessionName
+			}
+			if ar.Duration > 0 {
+				o.Duration = ar.Duration
+			}
+		})
+	awsCfg.Credentials = aws.NewCredentialsCache(provider)
+
+	return awsCfg
 }
 
 func (p *Provider) Name() string {
@@ -89,34 +251,165 @@ func (p *Provider) Name() string {
 }
 
 func (p *Provider) Get(rp viper.RemoteProvider) (io.Reader, error) {
+	bs, err := p.get(rp)
+	if err != nil {
+		if p.cache == nil {
+			return nil, err
+		}
+
+		bs, err = p.cache.Fallback(p.Name(), err)
+		if err != nil {
+			return nil, fmt.Errorf("viperaws.parameterstore.Provider.Get: %s, %w", p.basePath, err)
+		}
+
+		p.l.Warn("viperaws.parameterstore.Provider.Get: read the last known good value from the cache",
+			"basePath", p.basePath, "err", p.cache.Status().Err)
+	}
+
+	return bytes.NewReader(bs), nil
+}
+
+// get Get the parameters and remember the versions, the value is saved to the cache
+func (p *Provider) get(rp viper.RemoteProvider) ([]byte, error) {
 	result, err := p.GetResult(rp)
 	if err != nil {
 		return nil, err
 	}
 
+	p.versionsMu.Lock()
 	for k, v := range result.parameters {
-		p.versions[k] = v.Version
+		p.versions[k] = parameterVersion{name: v.Key, version: v.Version, secretVersion: v.SecretVersionID}
 	}
+	p.last = result
+	p.versionsMu.Unlock()
 
-	return result, nil
+	bs, err := result.JSON()
+	if err != nil {
+		return nil, err
+	}
+
+	p.saveCache(bs, result.versions())
+
+	return bs, nil
 }
 
-// GetResult Get the parameters by basePath
+// GetResult Get the parameters by basePath, later base paths override earlier ones,
+// or by the names of WithNames
 //
 // Required IAM policy:
 // Get the parameters by path: ssm:GetParametersByPath
+// Get the parameters by names: ssm:GetParameters
+// Tag filters and WithMetadata: ssm:DescribeParameters
+// WithLabel and WithSelectors: ssm:GetParameters
+// WithHistoryLabels: ssm:GetParameterHistory
+// WithJSONTag: ssm:DescribeParameters
+// WithSecretReferences: secretsmanager:GetSecretValue, secretsmanager:DescribeSecret
 func (p *Provider) GetResult(_ viper.RemoteProvider) (*Parameters, error) {
+	return p.getResult(nil)
+}
+
+// getResult Get the parameters, the parameters unchanged since prev carry over its metadata
+func (p *Provider) getResult(prev *Parameters) (*Parameters, error) {
+	ps, err := p.getParameters()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ps) == 0 {
+		return nil, fmt.Errorf("viperaws.parameterstore.Provider.GetResult: %s, %w",
+			p.basePath, ErrAwsSSMParametersEmpty)
+	}
+
+	err = p.resolveSelectors(ps)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.enrich(ps, prev)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.resolveSecrets(ps)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.expandJSON(ps, prev)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := p.newResult(ps)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.parameterstore.Provider.GetResult: %w", err)
+	}
+
+	return result, nil
+}
+
+// newResult returns the snapshot of the parameters
+func (p *Provider) newResult(ps map[string]*Parameter) (*Parameters, error) {
+	var result *Parameters
+	if len(p.names) > 0 {
+		// Viper keys, e.g. db.host is read as nested objects
+		result = newParameters("", ps, true, ".")
+	} else {
+		result = newParameters(p.basePaths[len(p.basePaths)-1], ps, p.nestedPaths, "/")
+	}
+
+	// Report leaf/branch conflicts before the document reaches viper
+	_, err := result.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (p *Provider) getParameters() (map[string]*Parameter, error) {
+	if len(p.names) > 0 {
+		return p.getByNames()
+	}
+
+	ps := make(map[string]*Parameter)
+
+	for _, bp := range p.basePaths {
+		layer, err := p.getByPath(bp)
+		if err != nil {
+			return nil, err
+		}
+
+		maps.Copy(ps, layer)
+	}
+
+	return ps, nil
+}
+
+// getByPath Get the parameters of a base path, keyed by the name under the base path
+func (p *Provider) getByPath(bp string) (map[string]*Parameter, error) {
 	getFn := func(next *string) (*ssm.GetParametersByPathOutput, error) {
 		input := &ssm.GetParametersByPathInput{
-			Path:           aws.String(p.basePath),
-			WithDecryption: aws.Bool(true),
-			MaxResults:     aws.Int32(10), // Maximum value of 10
-			NextToken:      next,
+			Path:             aws.String(bp),
+			Recursive:        aws.Bool(p.isRecursive()),
+			ParameterFilters: p.filters,
+			WithDecryption:   aws.Bool(true),
+			MaxResults:       aws.Int32(10), // Maximum value of 10
+			NextToken:        next,
 		}
 
 		return p.clt.GetParametersByPath(context.Background(), input)
 	}
 
+	var tagged map[string]bool
+	if len(p.tagFilters) > 0 {
+		var err error
+		tagged, err = p.taggedNames(p.pathScope(bp), p.tagFilters)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var next *string
 	ps := make(map[string]*Parameter)
 
@@ -126,7 +419,7 @@ func (p *Provider) GetResult(_ viper.RemoteProvider) (*Parameters, error) {
 			// For a list of exceptions thrown, see
 			// https://docs.aws.amazon.com/systems-manager/latest/APIReference/API_GetParametersByPath.html
 			return nil, fmt.Errorf("viperaws.parameterstore.Provider.GetResult: GetParametersByPath %s, %w",
-				p.basePath, err)
+				bp, err)
 		}
 
 		if result == nil {
@@ -139,13 +432,13 @@ func (p *Provider) GetResult(_ viper.RemoteProvider) (*Parameters, error) {
 					continue
 				}
 
-				k := strings.Replace(*v.Name, p.basePath, "", 1)
-				ps[k] = &Parameter{
-					Key:              *v.Name,
-					Value:            v.Value,
-					Version:          v.Version,
-					LastModifiedDate: *v.LastModifiedDate,
+				if tagged != nil && !tagged[*v.Name] {
+					continue
 				}
+
+				k := strings.TrimPrefix(*v.Name, bp)
+				ps[k] = newParameter(v)
+				ps[k].BasePath = bp
 			}
 		}
 
@@ -156,12 +449,257 @@ func (p *Provider) GetResult(_ viper.RemoteProvider) (*Parameters, error) {
 		next = result.NextToken
 	}
 
-	if len(ps) == 0 {
-		return nil, fmt.Errorf("viperaws.parameterstore.Provider.GetResult: %s, %w",
-			p.basePath, ErrAwsSSMParametersEmpty)
+	return ps, nil
+}
+
+func newParameter(v types.Parameter) *Parameter {
+	// The name of a selected parameter may include the selector
+	name, _, _ := strings.Cut(aws.ToString(v.Name), ":")
+
+	return &Parameter{
+		Key:              name,
+		Value:            v.Value,
+		Type:             v.Type,
+		DataType:         aws.ToString(v.DataType),
+		ARN:              aws.ToString(v.ARN),
+		Selector:         aws.ToString(v.Selector),
+		SourceResult:     aws.ToString(v.SourceResult),
+		Version:          v.Version,
+		LastModifiedDate: aws.ToTime(v.LastModifiedDate),
+	}
+}
+
+// resolveSelectors replaces the latest versions with the versions of WithLabel and WithSelectors,
+// parameters without the label or version keep the latest version
+func (p *Provider) resolveSelectors(ps map[string]*Parameter) error {
+	if p.label == "" && len(p.selectors) == 0 {
+		return nil
 	}
 
-	return NewParameters(p.basePath, ps), nil
+	keys := make([]string, 0, len(ps))
+	for k := range ps {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	names := make([]string, 0, len(keys))
+	keyByName := make(map[string]string, len(keys))
+	for _, k := range keys {
+		sel, ok := p.selectors[k]
+		if !ok {
+			sel = p.label
+		}
+		// The selector of a name of WithNames takes precedence
+		if sel != "" && !strings.Contains(p.names[k], ":") {
+			names = append(names, ps[k].Key+":"+sel)
+			keyByName[ps[k].Key] = k
+		}
+	}
+
+	// Maximum 10 names per call
+	for chunk := range slices.Chunk(names, 10) {
+		result, err := p.clt.GetParameters(context.Background(), &ssm.GetParametersInput{
+			Names:          chunk,
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("viperaws.parameterstore.Provider.resolveSelectors: GetParameters %s, %w",
+				p.basePath, err)
+		}
+
+		for _, v := range result.Parameters {
+			pp := newParameter(v)
+			k, ok := keyByName[pp.Key]
+			if !ok {
+				continue
+			}
+
+			pp.BasePath = ps[k].BasePath
+			ps[k] = pp
+		}
+
+		if len(result.InvalidParameters) > 0 {
+			p.l.Debug("viperaws.parameterstore.Provider.resolveSelectors: fallback to latest version",
+				"basePath", p.basePath, "invalidParameters", result.InvalidParameters)
+		}
+	}
+
+	return nil
+}
+
+// isRecursive reports whether parameters below the direct children of the base path are read
+func (p *Provider) isRecursive() bool {
+	return p.recursive || p.nestedPaths
+}
+
+// taggedNames returns the names of the parameters matching the tag filters,
+// GetParametersByPath does not accept tag filters, so they are listed with DescribeParameters.
+// It returns nil when no tag filter is set.
+func (p *Provider) taggedNames(
+	scope types.ParameterStringFilter, filters []types.ParameterStringFilter,
+) (map[string]bool, error) {
+	mds, err := p.describeParameters(scope, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(mds))
+	for _, v := range mds {
+		names[*v.Name] = true
+	}
+
+	return names, nil
+}
+
+// pathScope returns the Path filter limiting DescribeParameters to the base path
+func (p *Provider) pathScope(bp string) types.ParameterStringFilter {
+	opt := "OneLevel"
+	if p.isRecursive() {
+		opt = "Recursive"
+	}
+
+	return types.ParameterStringFilter{
+		Key:    aws.String("Path"),
+		Option: aws.String(opt),
+		Values: []string{strings.TrimSuffix(bp, "/")},
+	}
+}
+
+// describeScopes returns the filters limiting DescribeParameters to the read parameters
+func (p *Provider) describeScopes(ps map[string]*Parameter) []types.ParameterStringFilter {
+	if len(p.names) > 0 {
+		return nameScopes(parameterNames(ps))
+	}
+
+	scopes := make([]types.ParameterStringFilter, 0, len(p.basePaths))
+	for _, bp := range p.basePaths {
+		scopes = append(scopes, p.pathScope(bp))
+	}
+
+	return scopes
+}
+
+// describeParameters pages the metadata of the parameters in the scope, a base path or names,
+// matching the filters, values are neither downloaded nor decrypted
+func (p *Provider) describeParameters(
+	scope types.ParameterStringFilter, filters []types.ParameterStringFilter,
+) ([]types.ParameterMetadata, error) {
+	filters = append([]types.ParameterStringFilter{scope}, filters...)
+
+	var next *string
+	mds := make([]types.ParameterMetadata, 0)
+
+	for {
+		result, err := p.clt.DescribeParameters(context.Background(), &ssm.DescribeParametersInput{
+			ParameterFilters: filters,
+			MaxResults:       aws.Int32(50), // Maximum value of 50
+			NextToken:        next,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("viperaws.parameterstore.Provider.describeParameters: DescribeParameters %s, %w",
+				strings.Join(scope.Values, ","), err)
+		}
+
+		for _, v := range result.Parameters {
+			if v.Name != nil {
+				mds = append(mds, v)
+			}
+		}
+
+		if result.NextToken == nil {
+			break
+		}
+
+		next = result.NextToken
+	}
+
+	return mds, nil
+}
+
+// enrich sets the metadata of WithMetadata and WithHistoryLabels,
+// the parameters whose name and version are unchanged since prev carry over its metadata
+func (p *Provider) enrich(ps map[string]*Parameter, prev *Parameters) error {
+	if !p.metadata && !p.historyLabels {
+		return nil
+	}
+
+	byName := make(map[string]*Parameter, len(ps))
+	for k, v := range ps {
+		if pp := prev.sameVersion(k, v); pp != nil {
+			v.copyMetadata(pp)
+			continue
+		}
+		byName[v.Key] = v
+	}
+
+	if len(byName) == 0 {
+		return nil
+	}
+
+	if p.metadata {
+		// The watcher only describes the created and updated parameters
+		scopes := p.describeScopes(ps)
+		if prev != nil {
+			scopes = nameScopes(slices.Sorted(maps.Keys(byName)))
+		}
+
+		mds := make([]types.ParameterMetadata, 0, len(byName))
+		for _, scope := range scopes {
+			scopeMds, err := p.describeParameters(scope, nil)
+			if err != nil {
+				return err
+			}
+			mds = append(mds, scopeMds...)
+		}
+
+		for _, md := range mds {
+			pp, ok := byName[*md.Name]
+			if ok {
+				pp.setMetadata(md)
+			}
+		}
+	}
+
+	if p.historyLabels {
+		for name, pp := range byName {
+			labels, err := p.versionLabels(name, pp.Version)
+			if err != nil {
+				return err
+			}
+			pp.Labels = labels
+		}
+	}
+
+	return nil
+}
+
+// versionLabels returns the labels of the parameter version from its history
+func (p *Provider) versionLabels(name string, version int64) ([]string, error) {
+	var next *string
+
+	for {
+		result, err := p.clt.GetParameterHistory(context.Background(), &ssm.GetParameterHistoryInput{
+			Name:       aws.String(name),
+			MaxResults: aws.Int32(50), // Maximum value of 50
+			NextToken:  next,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("viperaws.parameterstore.Provider.versionLabels: GetParameterHistory %s, %w",
+				name, err)
+		}
+
+		for _, v := range result.Parameters {
+			if v.Version == version {
+				return v.Labels, nil
+			}
+		}
+
+		if result.NextToken == nil {
+			return nil, nil
+		}
+
+		next = result.NextToken
+	}
 }
 
 func (p *Provider) Watch(rp viper.RemoteProvider) (io.Reader, error) {
@@ -174,13 +712,11 @@ func (p *Provider) Watch(rp viper.RemoteProvider) (io.Reader, error) {
 	return r, nil
 }
 
-func (p *Provider) WatchChannel(rp viper.RemoteProvider) (<-chan *viper.RemoteResponse, chan bool) {
+func (p *Provider) WatchChannel(_ viper.RemoteProvider) (<-chan *viper.RemoteResponse, chan bool) {
 	p.l.Info("viperaws.parameterstore.Provider.WatchChannel: start watching...", "basePath", p.basePath)
 
-	ticker := time.NewTicker(p.watchInterval)
-
 	ch := make(chan *viper.RemoteResponse)
-	quit := make(chan bool)
+	stopped := p.startWatch()
 
 	go func() {
 		defer func() {
@@ -190,56 +726,83 @@ func (p *Provider) WatchChannel(rp viper.RemoteProvider) (<-chan *viper.RemoteRe
 			}
 		}()
 
+		poller := watch.NewPoller(p.watchInterval, p.watchStrategy)
+		timer := time.NewTimer(poller.First())
+
 		for {
 			select {
-			case <-ticker.C:
-				ps, err := p.GetResult(rp)
-				if err != nil {
-					p.l.Error("viperaws.parameterstore.Provider.WatchChannel, GetResult",
+			case <-timer.C:
+				err := watch.Classify(p.poll(ch))
+				d, stop := poller.Next(err)
+				switch {
+				case err == nil:
+				case stop:
+					p.l.Error("viperaws.parameterstore.Provider.WatchChannel: stop watching",
 						"basePath", p.basePath, "err", err)
-					continue
-				}
-
-				changes := p.getChanges(ps)
-				if len(changes.Created) == 0 && len(changes.Updated) == 0 && len(changes.Deleted) == 0 {
-					continue
-				}
-
-				buf := new(bytes.Buffer)
-				_, err = buf.ReadFrom(ps)
-				if err != nil {
-					p.l.Error("viperaws.parameterstore.Provider.WatchChannel, Read buffer",
-						"basePath", p.basePath, "err", err)
-					continue
-				}
-
-				ch <- &viper.RemoteResponse{
-					Value: buf.Bytes(),
-				}
-
-				if p.onChangeFunc != nil {
-					p.onChangeFunc(ps, changes)
+					p.stopWatch(stopped, err)
+					return
+				case err.Throttle:
+					p.l.Warn("viperaws.parameterstore.Provider.WatchChannel: throttled, backing off",
+						"basePath", p.basePath, "retryIn", d, "err", err)
+				default:
+					p.l.Error("viperaws.parameterstore.Provider.WatchChannel",
+						"basePath", p.basePath, "retryIn", d, "err", err)
 				}
+				timer.Reset(d)
 			case <-p.quit:
-				ticker.Stop()
+				timer.Stop()
 				return
 			}
 		}
 	}()
-	return ch, quit
+	return ch, p.quit
+}
+
+// poll sends the parameters to the channel when a parameter changed
+func (p *Provider) poll(ch chan<- *viper.RemoteResponse) error {
+	ps, err := p.watchResult(p.lastResult())
+	if err != nil {
+		return err
+	}
+
+	changes := p.getChanges(ps)
+	if len(changes.Created) == 0 && len(changes.Updated) == 0 && len(changes.Deleted) == 0 {
+		return nil
+	}
+
+	bs, err := ps.JSON()
+	if err != nil {
+		return err
+	}
+
+	p.saveCache(bs, ps.versions())
+
+	ch <- &viper.RemoteResponse{
+		Value: bs,
+	}
+
+	if p.onChangeFunc != nil {
+		p.onChangeFunc(ps, changes)
+	}
+
+	return nil
 }
 
 func (p *Provider) getChanges(ps *Parameters) *Changes {
+	p.versionsMu.Lock()
+	defer p.versionsMu.Unlock()
+
 	changes := &Changes{
 		Current: make([]string, 0),
 		Updated: make([]string, 0),
 		Created: make([]string, 0),
 		Deleted: make([]string, 0),
+		Layers:  make(map[string]string, len(ps.parameters)),
 	}
 	for k, v := range p.versions {
 		pp, ok := ps.parameters[k]
 		if ok {
-			if pp.Version != v {
+			if pp.Version != v.version || pp.Key != v.name || pp.SecretVersionID != v.secretVersion {
 				changes.Updated = append(changes.Updated, k)
 			}
 		} else {
@@ -248,21 +811,82 @@ func (p *Provider) getChanges(ps *Parameters) *Changes {
 		}
 	}
 
-	vs := make(map[string]int64, len(ps.parameters))
+	vs := make(map[string]parameterVersion, len(ps.parameters))
 	for k, v := range ps.parameters {
 		changes.Current = append(changes.Current, k)
-		vs[k] = v.Version
+		changes.Layers[k] = v.BasePath
+		vs[k] = parameterVersion{name: v.Key, version: v.Version, secretVersion: v.SecretVersionID}
 		if _, ok := p.versions[k]; !ok {
 			changes.Created = append(changes.Created, k)
 		}
 	}
 
 	p.versions = vs
+	p.last = ps
 
 	return changes
 }
 
+// lastResult returns the last snapshot read by Get or the watcher
+func (p *Provider) lastResult() *Parameters {
+	p.versionsMu.Lock()
+	defer p.versionsMu.Unlock()
+
+	return p.last
+}
+
 func (p *Provider) QuitWatch() {
 	p.l.Info("viperaws.parameterstore.Provider.QuitWatch", "basePath", p.basePath)
-	p.quit <- true
+	select {
+	case p.quit <- true:
+	case <-p.watchStopped():
+	}
+}
+
+// startWatch returns the stop signal of a new watcher, closed when it stops on an error of the watch policy
+func (p *Provider) startWatch() chan struct{} {
+	p.watchMu.Lock()
+	defer p.watchMu.Unlock()
+
+	p.stopped = make(chan struct{})
+
+	return p.stopped
+}
+
+// watchStopped returns the stop signal of the last watcher
+func (p *Provider) watchStopped() chan struct{} {
+	p.watchMu.Lock()
+	defer p.watchMu.Unlock()
+
+	return p.stopped
+}
+
+// stopWatch stops watching on an error of the watch policy
+func (p *Provider) stopWatch(stopped chan struct{}, err *watch.Error) {
+	close(stopped)
+
+	if p.onWatchStopFunc != nil {
+		p.onWatchStopFunc(err)
+	}
+}
+
+// saveCache saves the value to the cache, a failure only loses the last known good value
+func (p *Provider) saveCache(bs []byte, versions map[string]string) {
+	if p.cache == nil {
+		return
+	}
+
+	err := p.cache.Save(p.Name(), bs, versions)
+	if err != nil {
+		p.l.Warn("viperaws.parameterstore.Provider.saveCache", "basePath", p.basePath, "err", err)
+	}
+}
+
+// CacheStatus returns the status of WithCache, stale when the value was read from the cache
+func (p *Provider) CacheStatus() cache.Status {
+	if p.cache == nil {
+		return cache.Status{}
+	}
+
+	return p.cache.Status()
 }
@@ -0,0 +1,1190 @@
+package parameterstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/litsea/viper-aws/fake"
+	"github.com/litsea/viper-aws/internal/testutil"
+	"github.com/litsea/viper-aws/log"
+	"github.com/litsea/viper-aws/watch"
+)
+
+// withTestInterval polls faster than the minimum interval of WithWatchInterval
+func withTestInterval(d time.Duration) Option {
+	return func(p *Provider) {
+		p.watchInterval = d
+	}
+}
+
+func newTestProvider(t *testing.T, s *fake.SSM, opts ...Option) *Provider {
+	t.Helper()
+
+	p, err := NewConfigProvider(append(opts, WithClient(s), withTestInterval(10*time.Millisecond))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestProviderGet(t *testing.T) {
+	s := fake.NewSSM()
+	for i, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		s.PutParameter("/app/prod/"+name, string(rune('0'+i)), types.ParameterTypeString)
+	}
+
+	p := newTestProvider(t, s, WithBasePath("/app/prod"))
+	r, err := p.Get(nil)
+
+	want := `{"a":"0","b":"1","c":"2","d":"3","e":"4","f":"5","g":"6","h":"7","i":"8","j":"9","k":":","l":";"}`
+	if got := testutil.ReadAll(t, r, err); got != want {
+		t.Errorf("parameters: %s, expected %s", got, want)
+	}
+	if n := s.Calls("GetParametersByPath"); n != 2 {
+		t.Errorf("GetParametersByPath calls: %d, expected 2 pages", n)
+	}
+}
+
+func TestProviderWatchChannel(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/foo", "foo", types.ParameterTypeString)
+	s.PutParameter("/app/prod/bar", "bar", types.ParameterTypeString)
+
+	changed := make(chan *Changes, 1)
+	p := newTestProvider(t, s, WithBasePath("/app/prod/"),
+		WithOnChangeFunc(func(_ *Parameters, changes *Changes) {
+			changed <- changes
+		}))
+
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.PutParameter("/app/prod/foo", "foo2", types.ParameterTypeString)
+	s.PutParameter("/app/prod/baz", "baz", types.ParameterTypeString)
+	s.DeleteParameter("/app/prod/bar")
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	if got, want := testutil.Receive(t, ch), `{"baz":"baz","foo":"foo2"}`; got != want {
+		t.Errorf("watched parameters: %s, expected %s", got, want)
+	}
+
+	changes := <-changed
+	if !slices.Equal(changes.Created, []string{"baz"}) ||
+		!slices.Equal(changes.Updated, []string{"foo"}) ||
+		!slices.Equal(changes.Deleted, []string{"bar"}) {
+		t.Errorf("changes: %+v", changes)
+	}
+}
+
+func TestProviderWatchQuit(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/foo", "v1", types.ParameterTypeString)
+
+	p := newTestProvider(t, s, WithBasePath("/app/prod/"))
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, quit := p.WatchChannel(nil)
+
+	// The returned quit channel stops the watcher
+	select {
+	case quit <- true:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the watcher does not read the quit channel")
+	}
+
+	s.PutParameter("/app/prod/foo", "v2", types.ParameterTypeString)
+
+	select {
+	case resp := <-ch:
+		t.Errorf("received %s after quit", resp.Value)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestProviderRegion(t *testing.T) {
+	t.Setenv("AWS_REGION", "eu-west-1")
+
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"env", nil, "eu-west-1"},
+		{"explicit", []Option{WithRegion("ap-northeast-1")}, "ap-northeast-1"},
+		{"aws config", []Option{WithAWSConfig(aws.Config{Region: "us-west-2"})}, "us-west-2"},
+		{"explicit over aws config", []Option{
+			WithAWSConfig(aws.Config{Region: "us-west-2"}), WithRegion("ap-northeast-1"),
+		}, "ap-northeast-1"},
+		{"aws config without region", []Option{WithAWSConfig(aws.Config{})}, defaultRegion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, fake.NewSSM(),
+				append(tt.opts, WithBasePath("/app/prod"), WithAccessKey("test"), WithSecretKey("test"))...)
+			awsCfg, err := p.loadAWSConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if awsCfg.Region != tt.want {
+				t.Errorf("region: %s, expected %s", awsCfg.Region, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderAssumeRole(t *testing.T) {
+	p := newTestProvider(t, fake.NewSSM(),
+		WithBasePath("/app/prod"),
+		WithRegion("us-east-1"),
+		WithAccessKey("test"),
+		WithSecretKey("test"),
+		WithAssumeRole(AssumeRole{
+			RoleARN:     "arn:aws:iam::210987654321:role/config-reader",
+			ExternalID:  "app-a",
+			SessionName: "app-a-config",
+		}),
+	)
+
+	awsCfg, err := p.loadAWSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc, ok := awsCfg.Credentials.(*aws.CredentialsCache)
+	if !ok || !cc.IsCredentialsProvider(&stscreds.AssumeRoleProvider{}) {
+		t.Errorf("credentials: %T, expected a cached stscreds.AssumeRoleProvider", awsCfg.Credentials)
+	}
+}
+
+func TestProviderNestedPaths(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/db/host", "localhost", types.ParameterTypeString)
+	s.PutParameter("/app/prod/db/port", "5432", types.ParameterTypeString)
+	s.PutParameter("/app/prod/name", "app", types.ParameterTypeString)
+
+	p := newTestProvider(t, s, WithBasePath("/app/prod/"), WithNestedPaths(true))
+	r, err := p.Get(nil)
+
+	want := `{"db":{"host":"localhost","port":"5432"},"name":"app"}`
+	if got := testutil.ReadAll(t, r, err); got != want {
+		t.Errorf("nested parameters: %s, expected %s", got, want)
+	}
+
+	s.PutParameter("/app/prod/db", "conflict", types.ParameterTypeString)
+
+	_, err = p.GetResult(nil)
+	if !errors.Is(err, ErrAwsSSMParameterPathConflict) ||
+		!strings.Contains(err.Error(), "/app/prod/db and /app/prod/db/host,") {
+		t.Errorf("leaf/branch conflict error: %v", err)
+	}
+
+	// The expanded JSON value of a parameter conflicts with a child parameter
+	s.DeleteParameter("/app/prod/db/host")
+	s.DeleteParameter("/app/prod/db/port")
+	s.PutParameter("/app/prod/db", `{"host":"localhost"}`, types.ParameterTypeString)
+	s.PutParameter("/app/prod/db/host", "other", types.ParameterTypeString)
+
+	p = newTestProvider(t, s, WithBasePath("/app/prod/"), WithNestedPaths(true), WithJSONValues(true))
+	_, err = p.GetResult(nil)
+	if !errors.Is(err, ErrAwsSSMParameterPathConflict) ||
+		!strings.Contains(err.Error(), "/app/prod/db and /app/prod/db/host,") {
+		t.Errorf("expanded JSON conflict error: %v", err)
+	}
+
+	// A child parameter is not merged into the expanded JSON value either
+	s.DeleteParameter("/app/prod/db/host")
+	s.PutParameter("/app/prod/db/port", "5432", types.ParameterTypeString)
+
+	_, err = p.GetResult(nil)
+	if !errors.Is(err, ErrAwsSSMParameterPathConflict) ||
+		!strings.Contains(err.Error(), "/app/prod/db and /app/prod/db/port,") {
+		t.Errorf("expanded JSON child error: %v", err)
+	}
+}
+
+func TestProviderFilters(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/name", "app", types.ParameterTypeString)
+	s.PutParameter("/app/prod/db/host", "localhost", types.ParameterTypeString)
+	s.PutParameter("/app/prod/db/password", "p", types.ParameterTypeSecureString)
+	s.PutParameter("/app/prod/api/token", "t", types.ParameterTypeSecureString)
+	s.TagParameter("/app/prod/api/token", map[string]string{"team": "api"})
+	s.TagParameter("/app/prod/name", map[string]string{"team": "api"})
+
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"one level", nil, `{"name":"app"}`},
+		{"recursive", []Option{WithRecursive(true)},
+			`{"api/token":"t","db/host":"localhost","db/password":"p","name":"app"}`},
+		{"type", []Option{WithRecursive(true), WithParameterTypes(types.ParameterTypeSecureString)},
+			`{"api/token":"t","db/password":"p"}`},
+		{"tag", []Option{WithRecursive(true), WithTagFilter("team", "api")},
+			`{"api/token":"t","name":"app"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, s, append(tt.opts, WithBasePath("/app/prod/"))...)
+			r, err := p.Get(nil)
+			if got := testutil.ReadAll(t, r, err); got != tt.want {
+				t.Errorf("parameters: %s, expected %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderStringList(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/hosts", "a.local,b.local", types.ParameterTypeStringList)
+	s.PutParameter("/app/prod/name", "a,b", types.ParameterTypeString)
+
+	p := newTestProvider(t, s, WithBasePath("/app/prod/"))
+	ps, err := p.GetResult(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"hosts":["a.local","b.local"],"name":"a,b"}`
+	if got := testutil.ReadAll(t, ps, nil); got != want {
+		t.Errorf("parameters: %s, expected %s", got, want)
+	}
+
+	var cfg struct {
+		Hosts []string
+		Name  string
+	}
+	err = ps.Decode(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cfg.Hosts, []string{"a.local", "b.local"}) || cfg.Name != "a,b" {
+		t.Errorf("decoded parameters: %+v", cfg)
+	}
+}
+
+func TestProviderMetadata(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/password", "p1", types.ParameterTypeSecureString)
+	s.PutParameter("/app/prod/password", "p2", types.ParameterTypeSecureString)
+	s.SetParameterDescription("/app/prod/password", "database password")
+	s.LabelParameterVersion("/app/prod/password", 2, "stable")
+
+	p := newTestProvider(t, s, WithBasePath("/app/prod/"), WithMetadata(true), WithHistoryLabels(true))
+	ps, err := p.GetResult(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pp := ps.Get("password")
+	if pp.Type != types.ParameterTypeSecureString || pp.DataType != "text" || pp.ARN == "" {
+		t.Errorf("parameter fields: %+v", pp)
+	}
+	if pp.Description != "database password" || pp.KeyID == "" || pp.Tier != types.ParameterTierStandard {
+		t.Errorf("parameter metadata: %+v", pp)
+	}
+	if !slices.Equal(pp.Labels, []string{"stable"}) {
+		t.Errorf("parameter labels: %v", pp.Labels)
+	}
+}
+
+func TestProviderMetadataWatch(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/password", "p1", types.ParameterTypeSecureString)
+	s.SetParameterDescription("/app/prod/password", "database password")
+	s.LabelParameterVersion("/app/prod/password", 1, "stable")
+	s.PutParameter("/app/prod/user", "u1", types.ParameterTypeString)
+
+	changed := make(chan *Parameters, 1)
+	p := newTestProvider(t, s, WithBasePath("/app/prod/"), WithMetadata(true), WithHistoryLabels(true),
+		WithOnChangeFunc(func(ps *Parameters, _ *Changes) {
+			changed <- ps
+		}))
+
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := s.Calls("GetParameterHistory")
+	if history != 2 {
+		t.Errorf("GetParameterHistory calls: %d, expected 2", history)
+	}
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	// Unchanged ticks neither describe nor read the history
+	time.Sleep(50 * time.Millisecond)
+	describe := s.Calls("DescribeParameters")
+	if got := s.Calls("GetParameterHistory"); got != history {
+		t.Errorf("GetParameterHistory calls: %d, expected %d", got, history)
+	}
+
+	s.PutParameter("/app/prod/user", "u2", types.ParameterTypeString)
+	testutil.Receive(t, ch)
+	ps := <-changed
+
+	// Only the updated parameter is enriched, the other carries over its metadata
+	if got := s.Calls("GetParameterHistory"); got != history+1 {
+		t.Errorf("GetParameterHistory calls: %d, expected %d", got, history+1)
+	}
+	if got := s.Calls("DescribeParameters"); got != describe+1 {
+		t.Errorf("DescribeParameters calls: %d, expected %d", got, describe+1)
+	}
+
+	pp := ps.Get("password")
+	if pp.Description != "database password" || !slices.Equal(pp.Labels, []string{"stable"}) {
+		t.Errorf("carried metadata: %+v", pp)
+	}
+	if pp := ps.Get("user"); pp.Version != 2 || pp.KeyID != "" || pp.Tier != types.ParameterTierStandard {
+		t.Errorf("updated metadata: %+v", pp)
+	}
+}
+
+func TestProviderLabel(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/foo", "foo1", types.ParameterTypeString)
+	s.PutParameter("/app/prod/foo", "foo2", types.ParameterTypeString)
+	s.PutParameter("/app/prod/bar", "bar1", types.ParameterTypeString)
+	s.PutParameter("/app/prod/baz", "baz1", types.ParameterTypeString)
+	s.PutParameter("/app/prod/baz", "baz2", types.ParameterTypeString)
+	s.LabelParameterVersion("/app/prod/foo", 1, "stable")
+
+	p := newTestProvider(t, s, WithBasePath("/app/prod/"), WithLabel("stable"),
+		WithSelectors(map[string]string{"baz": "1"}))
+	r, err := p.Get(nil)
+
+	want := `{"bar":"bar1","baz":"baz1","foo":"foo1"}`
+	if got := testutil.ReadAll(t, r, err); got != want {
+		t.Errorf("labeled parameters: %s, expected %s", got, want)
+	}
+
+	s.LabelParameterVersion("/app/prod/foo", 2, "stable")
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	want = `{"bar":"bar1","baz":"baz1","foo":"foo2"}`
+	if got := testutil.Receive(t, ch); got != want {
+		t.Errorf("relabeled parameters: %s, expected %s", got, want)
+	}
+}
+
+func TestProviderLabelFilter(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/foo", "foo1", types.ParameterTypeString)
+	s.PutParameter("/app/prod/foo", "foo2", types.ParameterTypeString)
+	s.PutParameter("/app/prod/bar", "bar1", types.ParameterTypeString)
+	s.LabelParameterVersion("/app/prod/foo", 1, "stable")
+
+	tests := []struct {
+		name string
+		opt  Option
+		want string
+	}{
+		// Parameters without the label are left out
+		{"filter", WithLabelFilter("stable"), `{"foo":"foo1"}`},
+		// Parameters without the label fall back to the latest version
+		{"label", WithLabel("stable"), `{"bar":"bar1","foo":"foo1"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, s, WithBasePath("/app/prod/"), tt.opt)
+			r, err := p.Get(nil)
+			if got := testutil.ReadAll(t, r, err); got != tt.want {
+				t.Errorf("parameters: %s, expected %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderBasePaths(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/common/db_host", "common-db", types.ParameterTypeString)
+	s.PutParameter("/common/log_level", "info", types.ParameterTypeString)
+	s.PutParameter("/app/prod/db_host", "prod-db", types.ParameterTypeString)
+	s.PutParameter("/app/prod/name", "app", types.ParameterTypeString)
+
+	changed := make(chan *Changes, 1)
+	p := newTestProvider(t, s, WithBasePaths("/common", "/app/prod/"),
+		WithOnChangeFunc(func(_ *Parameters, changes *Changes) {
+			changed <- changes
+		}))
+
+	if got, want := p.Name(), "aws-parameterstore:/common/,/app/prod/"; got != want {
+		t.Errorf("name: %s, expected %s", got, want)
+	}
+
+	result, err := p.GetResult(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"db_host":"prod-db","log_level":"info","name":"app"}`
+	r, err := result.NewReader()
+	if got := testutil.ReadAll(t, r, err); got != want {
+		t.Errorf("parameters: %s, expected %s", got, want)
+	}
+	if pp := result.GetByFullPath("/common/log_level"); pp == nil || pp.BasePath != "/common/" {
+		t.Errorf("/common/log_level: %+v", pp)
+	}
+
+	_, err = p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting the override falls back to the lower layer
+	s.DeleteParameter("/app/prod/db_host")
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	want = `{"db_host":"common-db","log_level":"info","name":"app"}`
+	if got := testutil.Receive(t, ch); got != want {
+		t.Errorf("watched parameters: %s, expected %s", got, want)
+	}
+
+	changes := <-changed
+	if !slices.Equal(changes.Updated, []string{"db_host"}) ||
+		len(changes.Created) != 0 || len(changes.Deleted) != 0 {
+		t.Errorf("changes: %+v", changes)
+	}
+	if got := changes.Layers["db_host"]; got != "/common/" {
+		t.Errorf("db_host layer: %s, expected /common/", got)
+	}
+}
+
+func TestProviderNames(t *testing.T) {
+	s := fake.NewSSM()
+	for i := range 12 {
+		s.PutParameter(fmt.Sprintf("/shared/p%02d", i), strconv.Itoa(i), types.ParameterTypeString)
+	}
+	s.PutParameter("/app/token", "t1", types.ParameterTypeSecureString)
+	s.PutParameter("/app/token", "t2", types.ParameterTypeSecureString)
+
+	names := map[string]string{
+		"api.token": "/app/token:1",
+		"db.host":   "/shared/p00",
+	}
+	for i := range 12 {
+		names[fmt.Sprintf("p.p%02d", i)] = fmt.Sprintf("/shared/p%02d", i)
+	}
+
+	changed := make(chan *Changes, 1)
+	p := newTestProvider(t, s, WithNames(names),
+		WithOnChangeFunc(func(_ *Parameters, changes *Changes) {
+			changed <- changes
+		}))
+
+	result, err := p.GetResult(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := result.document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := doc["api"]; !reflect.DeepEqual(got, map[string]any{"token": "t1"}) {
+		t.Errorf("api: %v, expected the selected version", got)
+	}
+	if got := doc["db"]; !reflect.DeepEqual(got, map[string]any{"host": "0"}) {
+		t.Errorf("db: %v", got)
+	}
+	if n := s.Calls("GetParameters"); n != 2 {
+		t.Errorf("GetParameters calls: %d, expected 2 batches", n)
+	}
+	if n := s.Calls("GetParametersByPath"); n != 0 {
+		t.Errorf("GetParametersByPath calls: %d, expected none", n)
+	}
+
+	_, err = p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.PutParameter("/shared/p00", "new", types.ParameterTypeString)
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	testutil.Receive(t, ch)
+
+	// The same name is read once for both keys
+	changes := <-changed
+	slices.Sort(changes.Updated)
+	if !slices.Equal(changes.Updated, []string{"db.host", "p.p00"}) {
+		t.Errorf("changes: %+v", changes)
+	}
+}
+
+func TestProviderNamesInvalid(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/shared/foo", "foo", types.ParameterTypeString)
+
+	p := newTestProvider(t, s, WithNames(map[string]string{
+		"foo":     "/shared/foo",
+		"missing": "/shared/missing",
+	}))
+
+	_, err := p.Get(nil)
+
+	var invalid *InvalidParametersError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("error: %v, expected InvalidParametersError", err)
+	}
+	if !slices.Equal(invalid.Keys, []string{"missing"}) || !slices.Equal(invalid.Names, []string{"/shared/missing"}) {
+		t.Errorf("invalid parameters: %+v", invalid)
+	}
+
+	_, err = NewConfigProvider(WithClient(s), WithBasePath("/shared"), WithNames(map[string]string{"foo": "/shared/foo"}))
+	if !errors.Is(err, ErrAwsSSMNamesAndBasePaths) {
+		t.Errorf("error: %v, expected ErrAwsSSMNamesAndBasePaths", err)
+	}
+}
+
+type warnLogger struct {
+	log.EmptyLogger
+	mu    sync.Mutex
+	warns []any
+}
+
+func (l *warnLogger) Warn(_ string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.warns = append(l.warns, args...)
+}
+
+func TestProviderJSONValues(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/redis", `{"host":"redis","port":6379}`, types.ParameterTypeString)
+	s.PutParameter("/app/prod/hosts", `["a","b"]`, types.ParameterTypeString)
+	s.PutParameter("/app/prod/broken", `{"host":`, types.ParameterTypeString)
+	s.PutParameter("/app/prod/port", "8080", types.ParameterTypeString)
+	s.PutParameter("/app/prod/limits", "10", types.ParameterTypeString)
+	s.TagParameter("/app/prod/limits", map[string]string{"format": "json"})
+
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+		// warn is set when the broken value is expected to be logged
+		warn bool
+	}{
+		{
+			name: "disabled",
+			want: `{"broken":"{\"host\":","hosts":"[\"a\",\"b\"]","limits":"10","port":"8080",` +
+				`"redis":"{\"host\":\"redis\",\"port\":6379}"}`,
+		},
+		{
+			name: "all values",
+			opts: []Option{WithJSONValues(true)},
+			want: `{"broken":"{\"host\":","hosts":["a","b"],"limits":"10","port":"8080",` +
+				`"redis":{"host":"redis","port":6379}}`,
+			warn: true,
+		},
+		{
+			name: "tag",
+			opts: []Option{WithJSONTag("format", "json")},
+			want: `{"broken":"{\"host\":","hosts":"[\"a\",\"b\"]","limits":10,"port":"8080",` +
+				`"redis":"{\"host\":\"redis\",\"port\":6379}"}`,
+		},
+		{
+			name: "data type",
+			opts: []Option{WithJSONDataTypes("text")},
+			want: `{"broken":"{\"host\":","hosts":["a","b"],"limits":10,"port":8080,` +
+				`"redis":{"host":"redis","port":6379}}`,
+			warn: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &warnLogger{}
+			p := newTestProvider(t, s, append(tt.opts, WithBasePath("/app/prod"), WithLogger(l))...)
+			r, err := p.Get(nil)
+
+			if got := testutil.ReadAll(t, r, err); got != tt.want {
+				t.Errorf("parameters: %s, expected %s", got, tt.want)
+			}
+			if got := slices.Contains(l.warns, "broken"); got != tt.warn {
+				t.Errorf("warnings: %v, expected the broken key %t", l.warns, tt.warn)
+			}
+		})
+	}
+}
+
+func TestProviderJSONValuesWarnOnce(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/broken", `{"host":`, types.ParameterTypeString)
+	s.PutParameter("/app/prod/port", "8080", types.ParameterTypeString)
+
+	l := &warnLogger{}
+	p := newTestProvider(t, s, WithBasePath("/app/prod"), WithJSONValues(true), WithLogger(l))
+
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	// Unchanged ticks do not log the broken value again
+	time.Sleep(50 * time.Millisecond)
+	s.PutParameter("/app/prod/port", "8081", types.ParameterTypeString)
+	testutil.Receive(t, ch)
+
+	// A new version of the broken value is logged again
+	s.PutParameter("/app/prod/broken", `{"host":"`, types.ParameterTypeString)
+	testutil.Receive(t, ch)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+	for _, v := range l.warns {
+		if v == "broken" {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Errorf("warnings: %v, expected the broken key twice", l.warns)
+	}
+}
+
+func TestProviderSecretReferences(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("app/db", `{"user":"app","password":"p1"}`)
+
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/db", "secretsmanager:app/db", types.ParameterTypeString)
+	s.PutParameter("/app/prod/password", "secretsmanager:app/db#password", types.ParameterTypeString)
+	s.PutParameter("/app/prod/name", "app", types.ParameterTypeString)
+
+	changed := make(chan *Changes, 1)
+	p := newTestProvider(t, s, WithBasePath("/app/prod"), WithSecretsClient(sm),
+		WithOnChangeFunc(func(_ *Parameters, changes *Changes) {
+			changed <- changes
+		}))
+
+	r, err := p.Get(nil)
+
+	want := `{"db":{"password":"p1","user":"app"},"name":"app","password":"p1"}`
+	if got := testutil.ReadAll(t, r, err); got != want {
+		t.Errorf("parameters: %s, expected %s", got, want)
+	}
+	if n := sm.Calls("GetSecretValue"); n != 1 {
+		t.Errorf("GetSecretValue calls: %d, expected 1 for both references", n)
+	}
+
+	// An unchanged secret is not fetched again
+	_, err = p.GetResult(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := sm.Calls("GetSecretValue"); n != 1 {
+		t.Errorf("GetSecretValue calls: %d, expected the unchanged secret to be reused", n)
+	}
+
+	sm.PutSecretString("app/db", `{"user":"app","password":"p2"}`)
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	want = `{"db":{"password":"p2","user":"app"},"name":"app","password":"p2"}`
+	if got := testutil.Receive(t, ch); got != want {
+		t.Errorf("watched parameters: %s, expected %s", got, want)
+	}
+
+	changes := <-changed
+	slices.Sort(changes.Updated)
+	if !slices.Equal(changes.Updated, []string{"db", "password"}) {
+		t.Errorf("changes: %+v", changes)
+	}
+
+	s.PutParameter("/app/prod/password", "secretsmanager:app/db#missing", types.ParameterTypeString)
+	_, err = p.GetResult(nil)
+	if !errors.Is(err, ErrAwsSSMSecretReference) {
+		t.Errorf("error: %v, expected ErrAwsSSMSecretReference", err)
+	}
+}
+
+func TestProviderSecretsEndpoint(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("app/db", `{"password":"p1"}`)
+	smSrv := fake.NewServer(sm, nil)
+	defer smSrv.Close()
+
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/password", "secretsmanager:app/db#password", types.ParameterTypeString)
+	ssmSrv := fake.NewServer(nil, s)
+	defer ssmSrv.Close()
+
+	p, err := NewConfigProvider(
+		WithBasePath("/app/prod"),
+		WithRegion("us-east-1"),
+		WithAccessKey("test"),
+		WithSecretKey("test"),
+		WithEndpoint(ssmSrv.URL),
+		WithSecretsEndpoint(smSrv.URL),
+		WithSecretReferences(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := p.Get(nil)
+	if got := testutil.ReadAll(t, r, err); got != `{"password":"p1"}` {
+		t.Errorf("parameters: %s", got)
+	}
+	if n := sm.Calls("GetSecretValue"); n != 1 {
+		t.Errorf("GetSecretValue calls: %d, expected 1 on the Secrets Manager endpoint", n)
+	}
+}
+
+func TestParametersDecodeWithOptions(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/port", "8080", types.ParameterTypeString)
+	s.PutParameter("/app/prod/debug", "true", types.ParameterTypeString)
+	s.PutParameter("/app/prod/timeout", "1m30s", types.ParameterTypeString)
+	s.PutParameter("/app/prod/hosts", "a,b", types.ParameterTypeString)
+	s.PutParameter("/app/prod/zones", "x,y", types.ParameterTypeStringList)
+	s.PutParameter("/app/prod/ip", "10.0.0.1", types.ParameterTypeString)
+	s.PutParameter("/app/prod/endpoint", "https://example.com/api", types.ParameterTypeString)
+	s.PutParameter("/app/prod/db/host", "db", types.ParameterTypeString)
+	s.PutParameter("/app/prod/db/port", "5432", types.ParameterTypeString)
+	s.PutParameter("/app/prod/level", "warn", types.ParameterTypeString)
+
+	type db struct {
+		Host string `ssm:"host"`
+		Port int    `ssm:"port"`
+	}
+	type config struct {
+		Port     int           `ssm:"port"`
+		Debug    bool          `ssm:"debug"`
+		Timeout  time.Duration `ssm:"timeout"`
+		Hosts    []string      `ssm:"hosts"`
+		Zones    []string      `ssm:"zones"`
+		IP       net.IP        `ssm:"ip"`
+		Endpoint url.URL       `ssm:"endpoint"`
+		DB       db            `ssm:"db"`
+		Level    int           `ssm:"level"`
+	}
+
+	p := newTestProvider(t, s, WithBasePath("/app/prod"), WithRecursive(true))
+	result, err := p.GetResult(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	levels := func(f reflect.Type, t reflect.Type, data any) (any, error) {
+		if f.Kind() == reflect.String && t.Kind() == reflect.Int && data == "warn" {
+			return 2, nil
+		}
+		return data, nil
+	}
+
+	var cfg config
+	err = result.DecodeWithOptions(&cfg, DecodeTagName("ssm"), DecodeHooks(levels), DecodeErrorUnset(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := config{
+		Port:     8080,
+		Debug:    true,
+		Timeout:  90 * time.Second,
+		Hosts:    []string{"a", "b"},
+		Zones:    []string{"x", "y"},
+		IP:       net.ParseIP("10.0.0.1"),
+		Endpoint: url.URL{Scheme: "https", Host: "example.com", Path: "/api"},
+		DB:       db{Host: "db", Port: 5432},
+		Level:    2,
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("decoded: %+v, expected %+v", cfg, want)
+	}
+
+	var partial struct {
+		Port int `ssm:"port"`
+	}
+	err = result.DecodeWithOptions(&partial, DecodeTagName("ssm"), DecodeErrorUnused(true))
+	if err == nil {
+		t.Error("expected an error for the unused parameters")
+	}
+
+	err = result.DecodeWithOptions(&partial, DecodeTagName("ssm"), DecodeWeaklyTyped(false))
+	if err == nil {
+		t.Error("expected an error decoding a string into an int without weak typing")
+	}
+}
+
+func TestParametersConcurrentReads(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/db/host", "db", types.ParameterTypeString)
+	s.PutParameter("/app/prod/db/port", "5432", types.ParameterTypeString)
+
+	p := newTestProvider(t, s, WithBasePath("/app/prod"), WithNestedPaths(true))
+	result, err := p.GetResult(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"db":{"host":"db","port":"5432"}}`
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			r, err := result.NewReader()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got, _ := io.ReadAll(r); string(got) != want {
+				t.Errorf("parameters: %s, expected %s", got, want)
+			}
+
+			var cfg struct{ DB struct{ Port int } }
+			if err := result.DecodeWithOptions(&cfg); err != nil || cfg.DB.Port != 5432 {
+				t.Errorf("decoded: %+v, %v", cfg, err)
+			}
+
+			pr, err := p.Get(nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got, _ := io.ReadAll(pr); string(got) != want {
+				t.Errorf("parameters: %s, expected %s", got, want)
+			}
+
+			// Changing a copy does not change the snapshot
+			pp := result.Get("db/host")
+			pp.Value = nil
+			if got := result.GetValueByName("db/host"); got != "db" {
+				t.Errorf("db/host: %s, expected db", got)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestParametersImmutable(t *testing.T) {
+	value := "db"
+	pp := &Parameter{Key: "/app/prod/host", Value: &value, Type: types.ParameterTypeString}
+	ps := NewParameters("/app/prod/", map[string]*Parameter{"host": pp})
+
+	// Changing the parameters of the caller does not change the snapshot
+	value = "changed"
+	pp.Type = types.ParameterTypeStringList
+	if got := ps.GetValueByName("host"); got != "db" {
+		t.Errorf("host: %s, expected db", got)
+	}
+
+	// Changing the value of a copy does not change the snapshot
+	*ps.Get("host").Value = "x"
+	*ps.GetByFullPath("/app/prod/host").Value = "y"
+
+	var cfg struct{ Host string }
+	if err := ps.Decode(&cfg); err != nil || cfg.Host != "db" {
+		t.Errorf("decoded: %+v, %v", cfg, err)
+	}
+}
+
+func TestProviderWatchConcurrentCallbacks(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/foo", "0", types.ParameterTypeString)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	changed := make(chan *Parameters, 16)
+
+	p := newTestProvider(t, s, WithBasePath("/app/prod"),
+		WithOnChangeFunc(func(ps *Parameters, _ *Changes) {
+			// Read the snapshot from other goroutines while the watcher goes on
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				var out map[string]string
+				if err := ps.Decode(&out); err != nil {
+					t.Error(err)
+				}
+				if _, err := ps.JSON(); err != nil {
+					t.Error(err)
+				}
+				changed <- ps
+			}()
+		}))
+
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	go func() {
+		for {
+			select {
+			case <-ch:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for i := 1; i <= 5; i++ {
+		s.PutParameter("/app/prod/foo", strconv.Itoa(i), types.ParameterTypeString)
+
+		// Concurrent reads while the watcher reads the same parameters
+		_, err := p.GetResult(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case ps := <-changed:
+			if _, err := ps.NewReader(); err != nil {
+				t.Error(err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no change received from watcher")
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestProviderDescribeCheck(t *testing.T) {
+	s := fake.NewSSM()
+	for i := range 12 {
+		s.PutParameter(fmt.Sprintf("/app/prod/p%02d", i), strconv.Itoa(i), types.ParameterTypeSecureString)
+	}
+
+	changed := make(chan *Changes, 1)
+	p := newTestProvider(t, s, WithBasePath("/app/prod"), WithDescribeCheck(true), WithMetadata(true),
+		WithOnChangeFunc(func(_ *Parameters, changes *Changes) {
+			changed <- changes
+		}))
+
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byPath := s.Calls("GetParametersByPath")
+
+	s.PutParameter("/app/prod/p00", "new", types.ParameterTypeSecureString)
+	s.PutParameter("/app/prod/p12", "12", types.ParameterTypeString)
+	s.DeleteParameter("/app/prod/p01")
+	s.SetParameterDescription("/app/prod/p12", "created")
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	testutil.Receive(t, ch)
+
+	changes := <-changed
+	if !slices.Equal(changes.Created, []string{"p12"}) ||
+		!slices.Equal(changes.Updated, []string{"p00"}) ||
+		!slices.Equal(changes.Deleted, []string{"p01"}) {
+		t.Errorf("changes: %+v", changes)
+	}
+
+	ps := p.lastResult()
+	if got := ps.GetValueByName("p00"); got != "new" {
+		t.Errorf("p00: %s, expected new", got)
+	}
+	if got := ps.GetValueByName("p05"); got != "5" {
+		t.Errorf("p05: %s, expected the previous value", got)
+	}
+	if got := ps.Get("p12").Description; got != "created" {
+		t.Errorf("p12 description: %s, expected created", got)
+	}
+
+	if n := s.Calls("GetParametersByPath"); n != byPath {
+		t.Errorf("GetParametersByPath calls: %d, expected no path scan by the watcher", n-byPath)
+	}
+	if n := s.Calls("GetParameters"); n != 1 {
+		t.Errorf("GetParameters calls: %d, expected 1 for the changed parameters", n)
+	}
+}
+
+// throttlingClient throttles the first calls of GetParametersByPath
+type throttlingClient struct {
+	*fake.SSM
+	throttles atomic.Int32
+}
+
+func (c *throttlingClient) GetParametersByPath(ctx context.Context, params *ssm.GetParametersByPathInput,
+	optFns ...func(*ssm.Options),
+) (*ssm.GetParametersByPathOutput, error) {
+	if c.throttles.Add(-1) >= 0 {
+		return nil, &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+	}
+
+	return c.SSM.GetParametersByPath(ctx, params, optFns...)
+}
+
+func TestProviderWatchThrottled(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/host", "db", types.ParameterTypeString)
+
+	c := &throttlingClient{SSM: s}
+	l := &warnLogger{}
+	p, err := NewConfigProvider(WithClient(c), withTestInterval(10*time.Millisecond), WithBasePath("/app/prod"),
+		WithLogger(l), WithWatchStrategy(watch.Strategy{Multiplier: 2, MaxInterval: time.Second}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.throttles.Store(3)
+	s.PutParameter("/app/prod/host", "db2", types.ParameterTypeString)
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	if got := testutil.Receive(t, ch); got != `{"host":"db2"}` {
+		t.Errorf("watched parameters: %s", got)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The interval doubles on every throttling
+	delays := make([]time.Duration, 0)
+	for _, v := range l.warns {
+		if d, ok := v.(time.Duration); ok {
+			delays = append(delays, d)
+		}
+	}
+
+	want := []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 80 * time.Millisecond}
+	if !slices.Equal(delays, want) {
+		t.Errorf("delays: %v, expected %v", delays, want)
+	}
+}
+
+func TestProviderNamesDescribeCheck(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/host", "localhost", types.ParameterTypeString)
+	s.PutParameter("/app/prod/token", "t1", types.ParameterTypeSecureString)
+
+	// Type filters do not apply to names, the String parameter is still described
+	p := newTestProvider(t, s, WithDescribeCheck(true),
+		WithParameterTypes(types.ParameterTypeSecureString),
+		WithNames(map[string]string{"host": "/app/prod/host", "token": "/app/prod/token"}))
+
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byNames := s.Calls("GetParameters")
+	s.PutParameter("/app/prod/token", "t2", types.ParameterTypeSecureString)
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	if got := testutil.Receive(t, ch); got != `{"host":"localhost","token":"t2"}` {
+		t.Errorf("watched parameters: %s", got)
+	}
+
+	// Unchanged ticks only call DescribeParameters
+	time.Sleep(100 * time.Millisecond)
+	if n := s.Calls("GetParameters") - byNames; n != 1 {
+		t.Errorf("GetParameters calls: %d, expected 1 for the changed parameter", n)
+	}
+}
+
+func TestProviderCache(t *testing.T) {
+	s := fake.NewSSM()
+	s.PutParameter("/app/prod/host", "db", types.ParameterTypeString)
+
+	path := filepath.Join(t.TempDir(), "app.cache")
+
+	p := newTestProvider(t, s, WithBasePath("/app/prod"), WithCache(testutil.NewCache(t, path)))
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// AWS fails at startup, the last known good value is read from the cache
+	c := &throttlingClient{SSM: s}
+	c.throttles.Store(1)
+	p, err = NewConfigProvider(WithClient(c), withTestInterval(10*time.Millisecond), WithBasePath("/app/prod"),
+		WithCache(testutil.NewCache(t, path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := p.Get(nil)
+	if got := testutil.ReadAll(t, r, err); got != `{"host":"db"}` {
+		t.Errorf("cached parameters: %s", got)
+	}
+
+	st := p.CacheStatus()
+	if !st.Stale || st.Err == nil || st.Versions["/app/prod/host"] != "1" {
+		t.Errorf("status: %+v, expected stale", st)
+	}
+
+	// The watcher refreshes the value once AWS is reachable
+	s.PutParameter("/app/prod/host", "db2", types.ParameterTypeString)
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	if got := testutil.Receive(t, ch); got != `{"host":"db2"}` {
+		t.Errorf("watched parameters: %s", got)
+	}
+
+	st = p.CacheStatus()
+	if st.Stale || st.Versions["/app/prod/host"] != "2" {
+		t.Errorf("status: %+v, expected fresh", st)
+	}
+}
@@ -0,0 +1,140 @@
+package parameterstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// SecretReferencePrefix is the value prefix of the parameters referencing a secret,
+// e.g. secretsmanager:app/prod/db or secretsmanager:app/prod/db#password
+const SecretReferencePrefix = "secretsmanager:"
+
+var ErrAwsSSMSecretReference = errors.New("AWS SSM secret reference cannot be resolved")
+
+// SecretsClient is the Secrets Manager client resolving the secret references
+type SecretsClient interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput,
+		optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+	DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput,
+		optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error)
+}
+
+// secretValue is the AWSCURRENT version of a referenced secret
+type secretValue struct {
+	versionID string
+	value     string
+}
+
+// resolveSecrets resolves the parameters referencing a secret, a secret is fetched again
+// only when its AWSCURRENT version changes
+func (p *Provider) resolveSecrets(ps map[string]*Parameter) error {
+	if !p.secretRefs {
+		return nil
+	}
+
+	p.secretsMu.Lock()
+	defer p.secretsMu.Unlock()
+
+	// Sorted, so errors are reported the same way on every read
+	keys := make([]string, 0, len(ps))
+	for k, pp := range ps {
+		if strings.HasPrefix(pp.GetValue(), SecretReferencePrefix) {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	resolved := make(map[string]secretValue)
+	for _, k := range keys {
+		pp := ps[k]
+		id, key, _ := strings.Cut(strings.TrimPrefix(pp.GetValue(), SecretReferencePrefix), "#")
+
+		sv, ok := resolved[id]
+		if !ok {
+			var err error
+			sv, err = p.secretValue(id)
+			if err != nil {
+				return fmt.Errorf("viperaws.parameterstore.Provider.resolveSecrets: %s, %w", pp.Key, err)
+			}
+			resolved[id] = sv
+		}
+
+		_, err := secretDocumentValue(sv.value, key)
+		if err != nil {
+			return fmt.Errorf("viperaws.parameterstore.Provider.resolveSecrets: %s, %w", pp.Key, err)
+		}
+
+		pp.SecretID = id
+		pp.SecretVersionID = sv.versionID
+		pp.secret = &sv
+		pp.secretKey = key
+	}
+
+	p.secrets = resolved
+
+	return nil
+}
+
+// secretValue returns the AWSCURRENT version of the secret, from the previous read when unchanged
+func (p *Provider) secretValue(id string) (secretValue, error) {
+	prev, ok := p.secrets[id]
+	if ok {
+		out, err := p.secretsClt.DescribeSecret(context.Background(), &secretsmanager.DescribeSecretInput{
+			SecretId: aws.String(id),
+		})
+		if err != nil {
+			return secretValue{}, fmt.Errorf("DescribeSecret %s, %w", id, err)
+		}
+
+		if slices.Contains(out.VersionIdsToStages[prev.versionID], "AWSCURRENT") {
+			return prev, nil
+		}
+	}
+
+	out, err := p.secretsClt.GetSecretValue(context.Background(), &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return secretValue{}, fmt.Errorf("GetSecretValue %s, %w", id, err)
+	}
+
+	value := aws.ToString(out.SecretString)
+	if out.SecretString == nil {
+		value = string(out.SecretBinary)
+	}
+
+	return secretValue{versionID: aws.ToString(out.VersionId), value: value}, nil
+}
+
+// secretDocumentValue returns the JSON document of the secret, or the value of its top level key,
+// a secret which is not JSON is returned as a string
+func secretDocumentValue(value, key string) (any, error) {
+	var doc map[string]any
+	isDoc := json.Unmarshal([]byte(value), &doc) == nil
+
+	if key == "" {
+		if !isDoc {
+			return value, nil
+		}
+
+		return doc, nil
+	}
+
+	if !isDoc {
+		return nil, fmt.Errorf("key %s of a secret which is not a JSON object, %w", key, ErrAwsSSMSecretReference)
+	}
+
+	v, ok := doc[key]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in the secret, %w", key, ErrAwsSSMSecretReference)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,465 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/spf13/viper"
+
+	"github.com/litsea/viper-aws/cache"
+	"github.com/litsea/viper-aws/internal/jsonmap"
+	"github.com/litsea/viper-aws/watch"
+)
+
+var (
+	ErrAwsSecretsBatchIDsAndFilters = errors.New("AWS Secrets batch accepts either secret IDs or filters, not both")
+	ErrAwsSecretsBatchKeyConflict   = errors.New("AWS Secrets batch secrets are mounted under conflicting keys")
+	ErrAwsSecretsBatchOptions       = errors.New("AWS Secrets batch only reads the AWSCURRENT versions")
+)
+
+// BatchError reports the secrets BatchGetSecretValue failed to retrieve
+type BatchError struct {
+	Errors []types.APIErrorType
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, v := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("%s: %s %s",
+			aws.ToString(v.SecretId), aws.ToString(v.ErrorCode), aws.ToString(v.Message)))
+	}
+
+	return "AWS Secrets batch errors: " + strings.Join(msgs, "; ")
+}
+
+// BatchProvider implements reads configuration from AWS Secrets Manager with BatchGetSecretValue,
+// each secret is mounted under a viper key derived from its name.
+type BatchProvider struct {
+	p          *Provider
+	secretIDs  []string
+	versionIds map[string]string
+}
+
+// NewBatchConfigProvider returns a new BatchProvider,
+// the secrets are chosen either by ids or by WithBatchFilter options.
+// BatchGetSecretValue only reads the AWSCURRENT versions, so the version stage, version ID,
+// update stage and describe check options are rejected.
+func NewBatchConfigProvider(ids []string, opts ...Option) (*BatchProvider, error) {
+	p := newProvider(opts...)
+
+	if len(ids) == 0 && len(p.batchFilters) == 0 {
+		return nil, fmt.Errorf("viperaws.secrets.NewBatchConfigProvider: %w", ErrAwsSecretsIDsEmpty)
+	}
+
+	if len(ids) > 0 && len(p.batchFilters) > 0 {
+		return nil, fmt.Errorf("viperaws.secrets.NewBatchConfigProvider: %w", ErrAwsSecretsBatchIDsAndFilters)
+	}
+
+	if unsupported := batchUnsupportedOptions(p); len(unsupported) > 0 {
+		return nil, fmt.Errorf("viperaws.secrets.NewBatchConfigProvider: %s, %w",
+			strings.Join(unsupported, ", "), ErrAwsSecretsBatchOptions)
+	}
+
+	if p.batchKeyFunc == nil {
+		p.batchKeyFunc = defaultBatchKeyFunc(p.batchFilters)
+	}
+
+	err := p.loadClient()
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.secrets.NewBatchConfigProvider: %w", err)
+	}
+
+	return &BatchProvider{
+		p:          p,
+		secretIDs:  ids,
+		versionIds: make(map[string]string),
+	}, nil
+}
+
+// batchUnsupportedOptions returns the options set on p that BatchGetSecretValue cannot honor
+func batchUnsupportedOptions(p *Provider) []string {
+	var opts []string
+	if p.versionStage != "AWSCURRENT" {
+		opts = append(opts, "WithVersionStage")
+	}
+	if p.pinVersionId != "" {
+		opts = append(opts, "WithVersionID")
+	}
+	if p.updateStage {
+		opts = append(opts, "WithUpdateStage")
+	}
+	if p.describeCheck {
+		opts = append(opts, "WithDescribeCheck")
+	}
+
+	return opts
+}
+
+// defaultBatchKeyFunc trims the name prefix filter and maps the remaining path to a dotted viper key,
+// e.g. /app-a/prod/db with prefix /app-a/prod/ is mounted under "db"
+func defaultBatchKeyFunc(filters []types.Filter) func(name string) string {
+	var prefix string
+	for _, f := range filters {
+		if f.Key == types.FilterNameStringTypeName && len(f.Values) == 1 &&
+			!strings.HasPrefix(f.Values[0], "!") {
+			prefix = f.Values[0]
+		}
+	}
+
+	return func(name string) string {
+		name = strings.TrimPrefix(name, prefix)
+		return strings.ReplaceAll(strings.Trim(name, "/"), "/", ".")
+	}
+}
+
+func (bp *BatchProvider) Name() string {
+	if len(bp.secretIDs) > 0 {
+		return "aws-secrets-batch:" + strings.Join(bp.secretIDs, ",")
+	}
+
+	fs := make([]string, 0, len(bp.p.batchFilters))
+	for _, f := range bp.p.batchFilters {
+		fs = append(fs, string(f.Key)+"="+strings.Join(f.Values, "|"))
+	}
+
+	return "aws-secrets-batch:" + strings.Join(fs, ",")
+}
+
+func (bp *BatchProvider) Get(rp viper.RemoteProvider) (io.Reader, error) {
+	bs, err := bp.get(rp)
+	if err != nil {
+		if bp.p.cache == nil {
+			return nil, err
+		}
+
+		bs, err = bp.p.cache.Fallback(bp.Name(), err)
+		if err != nil {
+			return nil, fmt.Errorf("viperaws.secrets.BatchProvider.Get: %s, %w", bp.Name(), err)
+		}
+
+		bp.p.l.Warn("viperaws.secrets.BatchProvider.Get: read the last known good value from the cache",
+			"name", bp.Name(), "err", bp.p.cache.Status().Err)
+	}
+
+	return bytes.NewReader(bs), nil
+}
+
+// get Get the value and remember the versions, the value is saved to the cache
+func (bp *BatchProvider) get(rp viper.RemoteProvider) ([]byte, error) {
+	outs, err := bp.GetResults(rp)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, bs, err := bp.mount(outs)
+	if err != nil {
+		return nil, err
+	}
+
+	bp.versionIds = outsVersionIds(outs)
+	bp.p.doc = jsonmap.Flatten(doc)
+	bp.saveCache(bs, bp.versionIds)
+
+	return bs, nil
+}
+
+// GetResults Get the secret values with BatchGetSecretValue, sorted by secret name
+//
+// Required IAM policy:
+// secretsmanager:BatchGetSecretValue, secretsmanager:ListSecrets (filters),
+// and secretsmanager:GetSecretValue for every secret
+func (bp *BatchProvider) GetResults(_ viper.RemoteProvider) ([]*secretsmanager.GetSecretValueOutput, error) {
+	inputs := make([]*secretsmanager.BatchGetSecretValueInput, 0)
+	if len(bp.secretIDs) > 0 {
+		// Maximum 20 secret IDs per call
+		for ids := range slices.Chunk(bp.secretIDs, 20) {
+			inputs = append(inputs, &secretsmanager.BatchGetSecretValueInput{
+				SecretIdList: ids,
+			})
+		}
+	} else {
+		inputs = append(inputs, &secretsmanager.BatchGetSecretValueInput{
+			Filters:    bp.p.batchFilters,
+			MaxResults: aws.Int32(20),
+		})
+	}
+
+	outs := make([]*secretsmanager.GetSecretValueOutput, 0)
+	for _, input := range inputs {
+		for {
+			result, err := bp.p.clt.BatchGetSecretValue(context.Background(), input)
+			if err != nil {
+				// For a list of exceptions thrown, see
+				// https://docs.aws.amazon.com/secretsmanager/latest/apireference/API_BatchGetSecretValue.html
+				return nil, fmt.Errorf("viperaws.secrets.BatchProvider.GetResults: BatchGetSecretValue %s, %w",
+					bp.Name(), err)
+			}
+
+			if len(result.Errors) > 0 {
+				return nil, fmt.Errorf("viperaws.secrets.BatchProvider.GetResults: %s, %w",
+					bp.Name(), &BatchError{Errors: result.Errors})
+			}
+
+			for _, v := range result.SecretValues {
+				if v.Name == nil || v.VersionId == nil {
+					continue
+				}
+
+				outs = append(outs, &secretsmanager.GetSecretValueOutput{
+					ARN:           v.ARN,
+					CreatedDate:   v.CreatedDate,
+					Name:          v.Name,
+					SecretBinary:  v.SecretBinary,
+					SecretString:  v.SecretString,
+					VersionId:     v.VersionId,
+					VersionStages: v.VersionStages,
+				})
+			}
+
+			if result.NextToken == nil || len(input.SecretIdList) > 0 {
+				break
+			}
+
+			input.NextToken = result.NextToken
+		}
+	}
+
+	if len(outs) == 0 {
+		return nil, fmt.Errorf("viperaws.secrets.BatchProvider.GetResults: %s, %w",
+			bp.Name(), ErrAwsSecretsEmptyValue)
+	}
+
+	slices.SortFunc(outs, func(a, b *secretsmanager.GetSecretValueOutput) int {
+		return strings.Compare(*a.Name, *b.Name)
+	})
+
+	return outs, nil
+}
+
+// mount places every secret under the viper key derived from its name,
+// JSON secrets are mounted as objects, other secrets as strings.
+// Secrets mounted under the same key, or under a parent and a child key, are a conflict.
+func (bp *BatchProvider) mount(outs []*secretsmanager.GetSecretValueOutput) (map[string]any, []byte, error) {
+	doc := make(map[string]any)
+	owners := make(map[string]string)
+	for _, out := range outs {
+		bs, err := bp.p.value(out)
+		if err != nil {
+			return nil, nil, fmt.Errorf("viperaws.secrets.BatchProvider.mount: %s, %w", *out.Name, err)
+		}
+
+		var v any
+		if json.Unmarshal(bs, &v) != nil {
+			v = string(bs)
+		}
+
+		key := bp.p.batchKeyFunc(*out.Name)
+		if m, ok := v.(map[string]any); ok && key == "" {
+			// The secret name is the filter prefix itself, mount at the root
+			for _, k := range slices.Sorted(maps.Keys(m)) {
+				err = mountKey(owners, k, *out.Name)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+
+			doc = jsonmap.Merge(doc, m)
+			continue
+		}
+
+		if key == "" {
+			key = *out.Name
+		}
+
+		err = mountKey(owners, key, *out.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		segs := strings.Split(key, ".")
+		m := map[string]any{segs[len(segs)-1]: v}
+		for i := len(segs) - 2; i >= 0; i-- {
+			m = map[string]any{segs[i]: m}
+		}
+
+		doc = jsonmap.Merge(doc, m)
+	}
+
+	bs, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("viperaws.secrets.BatchProvider.mount: json.Marshal %s, %w", bp.Name(), err)
+	}
+
+	return doc, bs, nil
+}
+
+// mountKey records the secret mounted under the key, unless another secret is mounted
+// under the key, a parent or a child of it
+func mountKey(owners map[string]string, key, name string) error {
+	for _, k := range slices.Sorted(maps.Keys(owners)) {
+		if k == key || strings.HasPrefix(key, k+".") || strings.HasPrefix(k, key+".") {
+			return fmt.Errorf("viperaws.secrets.BatchProvider.mount: %s (%s) and %s (%s), %w",
+				owners[k], k, name, key, ErrAwsSecretsBatchKeyConflict)
+		}
+	}
+
+	owners[key] = name
+
+	return nil
+}
+
+func outsVersionIds(outs []*secretsmanager.GetSecretValueOutput) map[string]string {
+	vs := make(map[string]string, len(outs))
+	for _, out := range outs {
+		vs[aws.ToString(out.Name)] = aws.ToString(out.VersionId)
+	}
+
+	return vs
+}
+
+func (bp *BatchProvider) Watch(rp viper.RemoteProvider) (io.Reader, error) {
+	r, err := bp.Get(rp)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.secrets.BatchProvider.Watch: %w", err)
+	}
+
+	return r, nil
+}
+
+func (bp *BatchProvider) WatchChannel(rp viper.RemoteProvider) (<-chan *viper.RemoteResponse, chan bool) {
+	bp.p.l.Info("viperaws.secrets.BatchProvider.WatchChannel: start watching...", "name", bp.Name())
+
+	ch := make(chan *viper.RemoteResponse)
+	stopped := bp.p.startWatch()
+
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				bp.p.l.Error("viperaws.secrets.BatchProvider.WatchChannel: recovery form panic",
+					"err", fmt.Errorf("panic error: %v", err))
+			}
+		}()
+
+		poller := watch.NewPoller(bp.p.watchInterval, bp.p.watchStrategy)
+		timer := time.NewTimer(poller.First())
+
+		for {
+			select {
+			case <-timer.C:
+				err := watch.Classify(bp.poll(rp, ch))
+				d, stop := poller.Next(err)
+				switch {
+				case err == nil:
+				case stop:
+					bp.p.l.Error("viperaws.secrets.BatchProvider.WatchChannel: stop watching",
+						"name", bp.Name(), "err", err)
+					bp.p.stopWatch(stopped, err)
+					return
+				case err.Throttle:
+					bp.p.l.Warn("viperaws.secrets.BatchProvider.WatchChannel: throttled, backing off",
+						"name", bp.Name(), "retryIn", d, "err", err)
+				default:
+					bp.p.l.Error("viperaws.secrets.BatchProvider.WatchChannel",
+						"name", bp.Name(), "retryIn", d, "err", err)
+				}
+				timer.Reset(d)
+			case <-bp.p.quit:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return ch, bp.p.quit
+}
+
+// poll sends the mounted document to the channel when a secret changed
+func (bp *BatchProvider) poll(rp viper.RemoteProvider, ch chan<- *viper.RemoteResponse) error {
+	outs, err := bp.GetResults(rp)
+	if err != nil {
+		return err
+	}
+
+	changed := make([]*secretsmanager.GetSecretValueOutput, 0)
+	for _, out := range outs {
+		if bp.versionIds[*out.Name] != *out.VersionId {
+			changed = append(changed, out)
+		}
+	}
+
+	vids := outsVersionIds(outs)
+	deleted := make([]string, 0)
+	for name := range bp.versionIds {
+		if _, ok := vids[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	slices.Sort(deleted)
+
+	if len(changed) == 0 && len(deleted) == 0 {
+		return nil
+	}
+
+	doc, bs, err := bp.mount(outs)
+	if err != nil {
+		return err
+	}
+
+	bp.versionIds = vids
+	bp.saveCache(bs, bp.versionIds)
+
+	ch <- &viper.RemoteResponse{
+		Value: bs,
+	}
+
+	vs := make(map[string]string, len(changed))
+	for _, out := range changed {
+		if bp.p.onChangeFunc != nil {
+			bp.p.onChangeFunc(out)
+		}
+		vs[*out.Name] = *out.VersionId
+	}
+
+	bp.p.doc = notifyChanges(bp.p.doc, doc, vs, deleted, bp.p.sensitiveFunc, bp.p.onChangesFunc)
+
+	return nil
+}
+
+func (bp *BatchProvider) QuitWatch() {
+	bp.p.l.Info("viperaws.secrets.BatchProvider.QuitWatch", "name", bp.Name())
+	select {
+	case bp.p.quit <- true:
+	case <-bp.p.watchStopped():
+	}
+}
+
+// saveCache saves the value to the cache, a failure only loses the last known good value
+func (bp *BatchProvider) saveCache(bs []byte, versions map[string]string) {
+	if bp.p.cache == nil {
+		return
+	}
+
+	err := bp.p.cache.Save(bp.Name(), bs, versions)
+	if err != nil {
+		bp.p.l.Warn("viperaws.secrets.BatchProvider.saveCache", "name", bp.Name(), "err", err)
+	}
+}
+
+// CacheStatus returns the status of WithCache, stale when the value was read from the cache
+func (bp *BatchProvider) CacheStatus() cache.Status {
+	if bp.p.cache == nil {
+		return cache.Status{}
+	}
+
+	return bp.p.cache.Status()
+}
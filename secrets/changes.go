@@ -0,0 +1,159 @@
+package secrets
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/litsea/viper-aws/internal/jsonmap"
+)
+
+// Changes is the key-level change set of a secret JSON document,
+// nested keys are flattened with dots, e.g. db.password.
+// Old and New only hold the values of keys that are not sensitive,
+// VersionIds maps the changed secrets to their new version ID,
+// DeletedSecrets are the secrets of a BatchProvider that are not read anymore.
+type Changes struct {
+	VersionIds     map[string]string
+	DeletedSecrets []string
+	Current        []string
+	Created        []string
+	Updated        []string
+	Deleted        []string
+	Old            map[string]any
+	New            map[string]any
+}
+
+var (
+	// sensitiveSegments are matched against whole segments, e.g. db_password or auth.salt,
+	// so tokenizer or secretary are not sensitive
+	sensitiveSegments = []string{
+		"password", "passwd", "secret", "token", "credential", "credentials", "salt", "dsn",
+		"apikey", "accesskey", "privatekey", "secretkey",
+	}
+	// sensitiveLastSegments are matched against the last segment only,
+	// so api_key and private_key are sensitive but cache_key_prefix or private_network.cidr are not
+	sensitiveLastSegments = []string{"key"}
+)
+
+// DefaultSensitiveKey reports whether a segment of the key name is a common sensitive keyword,
+// segments are split on dots, underscores, hyphens and camel case, e.g. dbPassword
+func DefaultSensitiveKey(key string) bool {
+	segs := keySegments(key)
+	if len(segs) == 0 {
+		return false
+	}
+
+	for _, seg := range segs {
+		if slices.Contains(sensitiveSegments, seg) {
+			return true
+		}
+	}
+
+	return slices.Contains(sensitiveLastSegments, segs[len(segs)-1])
+}
+
+// keySegments splits the key name into lower case segments
+func keySegments(key string) []string {
+	var (
+		segs []string
+		seg  strings.Builder
+		prev rune
+	)
+
+	flush := func() {
+		if seg.Len() > 0 {
+			segs = append(segs, strings.ToLower(seg.String()))
+			seg.Reset()
+		}
+	}
+
+	for _, r := range key {
+		switch {
+		case r == '.' || r == '_' || r == '-':
+			flush()
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+			flush()
+			seg.WriteRune(r)
+		default:
+			seg.WriteRune(r)
+		}
+		prev = r
+	}
+	flush()
+
+	return segs
+}
+
+// getChanges diffs the flattened documents
+func getChanges(prev, cur map[string]any, sensitive func(key string) bool) *Changes {
+	changes := &Changes{
+		Current: make([]string, 0, len(cur)),
+		Created: make([]string, 0),
+		Updated: make([]string, 0),
+		Deleted: make([]string, 0),
+		Old:     make(map[string]any),
+		New:     make(map[string]any),
+	}
+
+	for k, v := range prev {
+		nv, ok := cur[k]
+		switch {
+		case !ok:
+			changes.Deleted = append(changes.Deleted, k)
+		case reflect.DeepEqual(v, nv):
+			continue
+		default:
+			changes.Updated = append(changes.Updated, k)
+		}
+
+		if !sensitive(k) {
+			changes.Old[k] = v
+		}
+	}
+
+	for k, v := range cur {
+		changes.Current = append(changes.Current, k)
+		pv, ok := prev[k]
+		if !ok {
+			changes.Created = append(changes.Created, k)
+		} else if reflect.DeepEqual(pv, v) {
+			continue
+		}
+
+		if !sensitive(k) {
+			changes.New[k] = v
+		}
+	}
+
+	slices.Sort(changes.Current)
+	slices.Sort(changes.Created)
+	slices.Sort(changes.Updated)
+	slices.Sort(changes.Deleted)
+
+	return changes
+}
+
+// Empty reports whether no key was created, updated or deleted
+func (c *Changes) Empty() bool {
+	return len(c.Created) == 0 && len(c.Updated) == 0 && len(c.Deleted) == 0
+}
+
+// notifyChanges calls fn with the key-level changes between the previous flattened document
+// and doc, it returns the flattened doc to diff the next change against
+func notifyChanges(prev, doc map[string]any, versionIds map[string]string, deleted []string,
+	sensitive func(key string) bool, fn func(changes *Changes),
+) map[string]any {
+	cur := jsonmap.Flatten(doc)
+	if fn == nil {
+		return cur
+	}
+
+	changes := getChanges(prev, cur, sensitive)
+	changes.VersionIds = versionIds
+	changes.DeletedSecrets = deleted
+	fn(changes)
+
+	return cur
+}
@@ -0,0 +1,49 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// changed reports whether the version attached to the watched stage differs from the last read version,
+// it calls DescribeSecret which neither downloads nor decrypts the secret value
+//
+// Required IAM policy: secretsmanager:DescribeSecret
+func (p *Provider) changed() (bool, error) {
+	// A version ID always points to the same value
+	if p.pinVersionId != "" && p.versionId != "" {
+		p.skippedFetches.Add(1)
+		return false, nil
+	}
+
+	out, err := p.clt.DescribeSecret(context.Background(), &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(p.secretID),
+	})
+	if err != nil {
+		return false, fmt.Errorf("viperaws.secrets.Provider.changed: DescribeSecret %s, %w",
+			p.secretID, err)
+	}
+
+	for id, stgs := range out.VersionIdsToStages {
+		if slices.Contains(stgs, p.versionStage) {
+			if id == p.versionId {
+				p.skippedFetches.Add(1)
+				return false, nil
+			}
+
+			return true, nil
+		}
+	}
+
+	// Stage not found, let GetSecretValue report the error
+	return true, nil
+}
+
+// SkippedFetches returns how many GetSecretValue calls were avoided by WithDescribeCheck
+func (p *Provider) SkippedFetches() int64 {
+	return p.skippedFetches.Load()
+}
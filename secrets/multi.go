@@ -0,0 +1,355 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/spf13/viper"
+
+	"github.com/litsea/viper-aws/cache"
+	"github.com/litsea/viper-aws/internal/jsonmap"
+	"github.com/litsea/viper-aws/log"
+	"github.com/litsea/viper-aws/watch"
+)
+
+var ErrAwsSecretsIDsEmpty = errors.New("AWS Secrets IDs is empty")
+
+// MultiProvider implements reads configuration merged from multiple AWS Secrets Manager secrets.
+type MultiProvider struct {
+	secretIDs       []string
+	providers       []*Provider
+	outs            []*secretsmanager.GetSecretValueOutput
+	watchInterval   time.Duration
+	watchStrategy   watch.Strategy
+	cache           *cache.Cache
+	quit            chan bool
+	watchMu         sync.Mutex
+	stopped         chan struct{}
+	onWatchStopFunc func(err *watch.Error)
+	l               log.Logger
+	onChangeFunc    func(out *secretsmanager.GetSecretValueOutput)
+	onChangesFunc   func(changes *Changes)
+	sensitiveFunc   func(key string) bool
+	doc             map[string]any
+}
+
+// NewMultiConfigProvider returns a new MultiProvider,
+// the JSON documents of the secrets are deep-merged in order, later secrets take priority.
+// Options are applied to every secret, the AWS client is shared.
+func NewMultiConfigProvider(ids []string, opts ...Option) (*MultiProvider, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("viperaws.secrets.NewMultiConfigProvider: %w", ErrAwsSecretsIDsEmpty)
+	}
+
+	mp := &MultiProvider{
+		secretIDs: ids,
+		providers: make([]*Provider, 0, len(ids)),
+		quit:      make(chan bool),
+	}
+
+	for i, id := range ids {
+		p := newProvider(append(opts, WithSecretID(id))...)
+		if i == 0 {
+			err := p.loadClient()
+			if err != nil {
+				return nil, fmt.Errorf("viperaws.secrets.NewMultiConfigProvider: %w", err)
+			}
+
+			mp.watchInterval = p.watchInterval
+			mp.watchStrategy = p.watchStrategy
+			mp.cache = p.cache
+			mp.onWatchStopFunc = p.onWatchStopFunc
+			mp.l = p.l
+			mp.onChangeFunc = p.onChangeFunc
+			mp.onChangesFunc = p.onChangesFunc
+			mp.sensitiveFunc = p.sensitiveFunc
+		} else {
+			p.clt = mp.providers[0].clt
+		}
+
+		mp.providers = append(mp.providers, p)
+	}
+
+	return mp, nil
+}
+
+func (mp *MultiProvider) Name() string {
+	return "aws-secrets:" + strings.Join(mp.secretIDs, ",")
+}
+
+func (mp *MultiProvider) Get(rp viper.RemoteProvider) (io.Reader, error) {
+	bs, err := mp.get(rp)
+	if err != nil {
+		if mp.cache == nil {
+			return nil, err
+		}
+
+		bs, err = mp.cache.Fallback(mp.Name(), err)
+		if err != nil {
+			return nil, fmt.Errorf("viperaws.secrets.MultiProvider.Get: %s, %w", mp.Name(), err)
+		}
+
+		mp.l.Warn("viperaws.secrets.MultiProvider.Get: read the last known good value from the cache",
+			"secretIDs", mp.secretIDs, "err", mp.cache.Status().Err)
+	}
+
+	return bytes.NewReader(bs), nil
+}
+
+// get Get the value and remember the versions, the value is saved to the cache
+func (mp *MultiProvider) get(rp viper.RemoteProvider) ([]byte, error) {
+	outs, err := mp.GetResults(rp)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, bs, err := mp.merge(outs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, p := range mp.providers {
+		p.versionId = aws.ToString(outs[i].VersionId)
+	}
+	mp.outs = outs
+	mp.doc = jsonmap.Flatten(doc)
+	mp.saveCache(bs, outsVersionIds(outs))
+
+	return bs, nil
+}
+
+// GetResults Get the secret values of all secrets, in the order of the secret IDs
+//
+// Required IAM policy: see Provider.GetResult
+func (mp *MultiProvider) GetResults(rp viper.RemoteProvider) ([]*secretsmanager.GetSecretValueOutput, error) {
+	outs := make([]*secretsmanager.GetSecretValueOutput, 0, len(mp.providers))
+	for _, p := range mp.providers {
+		out, err := p.GetResult(rp)
+		if err != nil {
+			return nil, fmt.Errorf("viperaws.secrets.MultiProvider.GetResults: %w", err)
+		}
+		outs = append(outs, out)
+	}
+
+	return outs, nil
+}
+
+// watchResults is like GetResults, but reuses the last result of secrets
+// whose version is unchanged according to WithDescribeCheck
+func (mp *MultiProvider) watchResults(rp viper.RemoteProvider) ([]*secretsmanager.GetSecretValueOutput, error) {
+	outs := make([]*secretsmanager.GetSecretValueOutput, 0, len(mp.providers))
+	for i, p := range mp.providers {
+		if p.describeCheck && len(mp.outs) == len(mp.providers) {
+			changed, err := p.changed()
+			if err != nil {
+				return nil, fmt.Errorf("viperaws.secrets.MultiProvider.watchResults: %w", err)
+			}
+			if !changed {
+				outs = append(outs, mp.outs[i])
+				continue
+			}
+		}
+
+		out, err := p.GetResult(rp)
+		if err != nil {
+			return nil, fmt.Errorf("viperaws.secrets.MultiProvider.watchResults: %w", err)
+		}
+		outs = append(outs, out)
+	}
+
+	return outs, nil
+}
+
+// SkippedFetches returns how many GetSecretValue calls were avoided by WithDescribeCheck
+func (mp *MultiProvider) SkippedFetches() int64 {
+	var n int64
+	for _, p := range mp.providers {
+		n += p.SkippedFetches()
+	}
+
+	return n
+}
+
+func (mp *MultiProvider) merge(outs []*secretsmanager.GetSecretValueOutput) (map[string]any, []byte, error) {
+	var doc map[string]any
+	for i, p := range mp.providers {
+		m, err := p.document(outs[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("viperaws.secrets.MultiProvider.merge: %w", err)
+		}
+		doc = jsonmap.Merge(doc, m)
+	}
+
+	bs, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("viperaws.secrets.MultiProvider.merge: json.Marshal %s, %w",
+			strings.Join(mp.secretIDs, ","), err)
+	}
+
+	return doc, bs, nil
+}
+
+func (mp *MultiProvider) Watch(rp viper.RemoteProvider) (io.Reader, error) {
+	r, err := mp.Get(rp)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.secrets.MultiProvider.Watch: %w", err)
+	}
+
+	return r, nil
+}
+
+func (mp *MultiProvider) WatchChannel(rp viper.RemoteProvider) (<-chan *viper.RemoteResponse, chan bool) {
+	mp.l.Info("viperaws.secrets.MultiProvider.WatchChannel: start watching...", "secretIDs", mp.secretIDs)
+
+	ch := make(chan *viper.RemoteResponse)
+	stopped := mp.startWatch()
+
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				mp.l.Error("viperaws.secrets.MultiProvider.WatchChannel: recovery form panic",
+					"err", fmt.Errorf("panic error: %v", err))
+			}
+		}()
+
+		poller := watch.NewPoller(mp.watchInterval, mp.watchStrategy)
+		timer := time.NewTimer(poller.First())
+
+		for {
+			select {
+			case <-timer.C:
+				err := watch.Classify(mp.poll(rp, ch))
+				d, stop := poller.Next(err)
+				switch {
+				case err == nil:
+				case stop:
+					mp.l.Error("viperaws.secrets.MultiProvider.WatchChannel: stop watching",
+						"secretIDs", mp.secretIDs, "err", err)
+					mp.stopWatch(stopped, err)
+					return
+				case err.Throttle:
+					mp.l.Warn("viperaws.secrets.MultiProvider.WatchChannel: throttled, backing off",
+						"secretIDs", mp.secretIDs, "retryIn", d, "err", err)
+				default:
+					mp.l.Error("viperaws.secrets.MultiProvider.WatchChannel",
+						"secretIDs", mp.secretIDs, "retryIn", d, "err", err)
+				}
+				timer.Reset(d)
+			case <-mp.quit:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return ch, mp.quit
+}
+
+// poll sends the merged document to the channel when a secret version changed
+func (mp *MultiProvider) poll(rp viper.RemoteProvider, ch chan<- *viper.RemoteResponse) error {
+	outs, err := mp.watchResults(rp)
+	if err != nil {
+		return err
+	}
+
+	changed := make([]*secretsmanager.GetSecretValueOutput, 0)
+	for i, p := range mp.providers {
+		if p.versionId != *outs[i].VersionId {
+			changed = append(changed, outs[i])
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	doc, bs, err := mp.merge(outs)
+	if err != nil {
+		return err
+	}
+
+	for i, p := range mp.providers {
+		p.versionId = aws.ToString(outs[i].VersionId)
+	}
+	mp.outs = outs
+	mp.saveCache(bs, outsVersionIds(outs))
+
+	ch <- &viper.RemoteResponse{
+		Value: bs,
+	}
+
+	vs := make(map[string]string, len(changed))
+	for _, out := range changed {
+		if mp.onChangeFunc != nil {
+			mp.onChangeFunc(out)
+		}
+		vs[aws.ToString(out.Name)] = aws.ToString(out.VersionId)
+	}
+
+	mp.doc = notifyChanges(mp.doc, doc, vs, nil, mp.sensitiveFunc, mp.onChangesFunc)
+
+	return nil
+}
+
+func (mp *MultiProvider) QuitWatch() {
+	mp.l.Info("viperaws.secrets.MultiProvider.QuitWatch", "secretIDs", mp.secretIDs)
+	select {
+	case mp.quit <- true:
+	case <-mp.watchStopped():
+	}
+}
+
+// startWatch returns the stop signal of a new watcher, closed when it stops on an error of the watch policy
+func (mp *MultiProvider) startWatch() chan struct{} {
+	mp.watchMu.Lock()
+	defer mp.watchMu.Unlock()
+
+	mp.stopped = make(chan struct{})
+
+	return mp.stopped
+}
+
+// watchStopped returns the stop signal of the last watcher
+func (mp *MultiProvider) watchStopped() chan struct{} {
+	mp.watchMu.Lock()
+	defer mp.watchMu.Unlock()
+
+	return mp.stopped
+}
+
+// stopWatch stops watching on an error of the watch policy
+func (mp *MultiProvider) stopWatch(stopped chan struct{}, err *watch.Error) {
+	close(stopped)
+
+	if mp.onWatchStopFunc != nil {
+		mp.onWatchStopFunc(err)
+	}
+}
+
+// saveCache saves the value to the cache, a failure only loses the last known good value
+func (mp *MultiProvider) saveCache(bs []byte, versions map[string]string) {
+	if mp.cache == nil {
+		return
+	}
+
+	err := mp.cache.Save(mp.Name(), bs, versions)
+	if err != nil {
+		mp.l.Warn("viperaws.secrets.MultiProvider.saveCache", "secretIDs", mp.secretIDs, "err", err)
+	}
+}
+
+// CacheStatus returns the status of WithCache, stale when the value was read from the cache
+func (mp *MultiProvider) CacheStatus() cache.Status {
+	if mp.cache == nil {
+		return cache.Status{}
+	}
+
+	return mp.cache.Status()
+}
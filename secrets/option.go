@@ -3,9 +3,13 @@ package secrets
 import (
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 
+	"github.com/litsea/viper-aws/cache"
 	"github.com/litsea/viper-aws/log"
+	"github.com/litsea/viper-aws/watch"
 )
 
 type Option func(p *Provider)
@@ -16,12 +20,61 @@ func WithSecretID(id string) Option {
 	}
 }
 
+// WithClient uses the given client instead of creating one from the AWS config
+func WithClient(c Client) Option {
+	return func(p *Provider) {
+		p.clt = c
+	}
+}
+
+// WithAWSConfig builds the client from the given AWS config instead of LoadDefaultConfig,
+// retryers, HTTP clients and middlewares of the config are kept
+func WithAWSConfig(cfg aws.Config) Option {
+	return func(p *Provider) {
+		p.awsCfg = &cfg
+	}
+}
+
+// WithProfile loads the AWS config with the shared config profile
+func WithProfile(name string) Option {
+	return func(p *Provider) {
+		p.profile = name
+	}
+}
+
+// AssumeRole is the IAM role assumed with STS, e.g. to read config of another account
+type AssumeRole struct {
+	RoleARN     string
+	ExternalID  string
+	SessionName string
+	// Duration of the role session, defaults to 15 minutes
+	Duration time.Duration
+}
+
+// WithAssumeRole assumes the role with the configured credentials,
+// the temporary credentials are refreshed automatically before they expire
+func WithAssumeRole(ar AssumeRole) Option {
+	return func(p *Provider) {
+		p.assumeRole = &ar
+	}
+}
+
+// WithRegion sets the region, it takes precedence over AWS_REGION,
+// defaults to the region of the AWS config or us-east-1
 func WithRegion(r string) Option {
 	return func(p *Provider) {
 		p.region = r
 	}
 }
 
+// WithEndpoint overrides the service endpoint URL,
+// e.g. LocalStack, a VPC endpoint or a fake.NewServer
+func WithEndpoint(url string) Option {
+	return func(p *Provider) {
+		p.endpoint = url
+	}
+}
+
 func WithAccessKey(ak string) Option {
 	return func(p *Provider) {
 		p.accessKey = ak
@@ -40,6 +93,24 @@ func WithSessionToken(t string) Option {
 	}
 }
 
+// WithVersionStage reads and watches the version attached to the staging label,
+// e.g. AWSPENDING or a v2006.0102.150405 label created by WithUpdateStage
+func WithVersionStage(stg string) Option {
+	return func(p *Provider) {
+		if stg != "" {
+			p.versionStage = stg
+		}
+	}
+}
+
+// WithVersionID pins the provider to a specific version ID,
+// it takes precedence over WithVersionStage
+func WithVersionID(id string) Option {
+	return func(p *Provider) {
+		p.pinVersionId = id
+	}
+}
+
 func WithUpdateStage(u bool) Option {
 	return func(p *Provider) {
 		p.updateStage = u
@@ -54,6 +125,41 @@ func WithKeepStages(i int) Option {
 	}
 }
 
+// WithBinaryDecoding sets how SecretBinary payloads are decoded,
+// it is only used when the secret has no SecretString
+func WithBinaryDecoding(d BinaryDecoding) Option {
+	return func(p *Provider) {
+		p.binaryDecoding = d
+	}
+}
+
+// WithDescribeCheck makes the watcher call DescribeSecret on every tick
+// and only call GetSecretValue when the watched version changed
+func WithDescribeCheck(d bool) Option {
+	return func(p *Provider) {
+		p.describeCheck = d
+	}
+}
+
+// WithBatchFilter adds a BatchGetSecretValue filter, e.g. name prefix /app-a/prod/,
+// only used by BatchProvider
+func WithBatchFilter(key types.FilterNameStringType, values ...string) Option {
+	return func(p *Provider) {
+		p.batchFilters = append(p.batchFilters, types.Filter{
+			Key:    key,
+			Values: values,
+		})
+	}
+}
+
+// WithBatchKeyFunc sets how a secret name is mapped to a dotted viper key,
+// only used by BatchProvider
+func WithBatchKeyFunc(fn func(name string) string) Option {
+	return func(p *Provider) {
+		p.batchKeyFunc = fn
+	}
+}
+
 func WithWatchInterval(w time.Duration) Option {
 	return func(p *Provider) {
 		if w > time.Second {
@@ -62,6 +168,30 @@ func WithWatchInterval(w time.Duration) Option {
 	}
 }
 
+// WithWatchStrategy sets the polling strategy of the watcher, defaults to watch.DefaultStrategy,
+// e.g. watch.Strategy{} polls at the fixed watch interval
+func WithWatchStrategy(s watch.Strategy) Option {
+	return func(p *Provider) {
+		p.watchStrategy = s
+	}
+}
+
+// WithOnWatchStopFunc is called when the watcher stops on an error, see watch.Policy
+func WithOnWatchStopFunc(fn func(err *watch.Error)) Option {
+	return func(p *Provider) {
+		p.onWatchStopFunc = fn
+	}
+}
+
+// WithCache saves every fetched value to the cache and reads the last saved value
+// when a read fails, e.g. AWS is unreachable at startup, the watcher refreshes it.
+// A value saved by a provider of another name, e.g. after the secret ID changed, is not read.
+func WithCache(c *cache.Cache) Option {
+	return func(p *Provider) {
+		p.cache = c
+	}
+}
+
 func WithLogger(l log.Logger) Option {
 	return func(p *Provider) {
 		if l != nil {
@@ -75,3 +205,20 @@ func WithOnChangeFunc(fn func(out *secretsmanager.GetSecretValueOutput)) Option
 		p.onChangeFunc = fn
 	}
 }
+
+// WithOnChangesFunc sets the callback of the key-level changes of the secret JSON document
+func WithOnChangesFunc(fn func(changes *Changes)) Option {
+	return func(p *Provider) {
+		p.onChangesFunc = fn
+	}
+}
+
+// WithSensitiveKeyFunc sets which flattened keys are sensitive,
+// their values are left out of Changes, defaults to DefaultSensitiveKey
+func WithSensitiveKeyFunc(fn func(key string) bool) Option {
+	return func(p *Provider) {
+		if fn != nil {
+			p.sensitiveFunc = fn
+		}
+	}
+}
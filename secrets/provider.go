@@ -1,85 +1,220 @@
 package secrets
 
 import (
+	"bytes"
 	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"os"
 	"slices"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/spf13/viper"
 
+	"github.com/litsea/viper-aws/cache"
 	"github.com/litsea/viper-aws/log"
+	"github.com/litsea/viper-aws/watch"
 )
 
+const defaultRegion = "us-east-1"
+
 var ErrAwsSecretsEmptyValue = errors.New("AWS Secrets value is empty")
 
+// Client is the subset of the Secrets Manager API used by the providers,
+// it is implemented by *secretsmanager.Client and fake.SecretsManager
+type Client interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput,
+		optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+	DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput,
+		optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error)
+	BatchGetSecretValue(ctx context.Context, params *secretsmanager.BatchGetSecretValueInput,
+		optFns ...func(*secretsmanager.Options)) (*secretsmanager.BatchGetSecretValueOutput, error)
+	ListSecretVersionIds(ctx context.Context, params *secretsmanager.ListSecretVersionIdsInput,
+		optFns ...func(*secretsmanager.Options)) (*secretsmanager.ListSecretVersionIdsOutput, error)
+	UpdateSecretVersionStage(ctx context.Context, params *secretsmanager.UpdateSecretVersionStageInput,
+		optFns ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error)
+}
+
 // Provider implements reads configuration from AWS Secrets Manager.
 type Provider struct {
-	clt           *secretsmanager.Client
-	region        string
-	secretID      string
-	accessKey     string
-	secretKey     string
-	sessionToken  string
-	versionId     string
-	updateStage   bool
-	keepStages    int
-	watchInterval time.Duration
-	quit          chan bool
-	l             log.Logger
-	onChangeFunc  func(out *secretsmanager.GetSecretValueOutput)
+	clt             Client
+	region          string
+	secretID        string
+	accessKey       string
+	secretKey       string
+	sessionToken    string
+	endpoint        string
+	profile         string
+	awsCfg          *aws.Config
+	assumeRole      *AssumeRole
+	versionId       string
+	versionStage    string
+	pinVersionId    string
+	updateStage     bool
+	keepStages      int
+	binaryDecoding  BinaryDecoding
+	describeCheck   bool
+	skippedFetches  atomic.Int64
+	batchFilters    []types.Filter
+	batchKeyFunc    func(name string) string
+	watchInterval   time.Duration
+	watchStrategy   watch.Strategy
+	cache           *cache.Cache
+	quit            chan bool
+	watchMu         sync.Mutex
+	stopped         chan struct{}
+	onWatchStopFunc func(err *watch.Error)
+	l               log.Logger
+	onChangeFunc    func(out *secretsmanager.GetSecretValueOutput)
+	onChangesFunc   func(changes *Changes)
+	sensitiveFunc   func(key string) bool
+	doc             map[string]any
 }
 
 // NewConfigProvider returns a new Provider.
 func NewConfigProvider(opts ...Option) (*Provider, error) {
+	p := newProvider(opts...)
+
+	err := p.loadClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func newProvider(opts ...Option) *Provider {
 	p := &Provider{
-		region:        "us-east-1",
+		versionStage:  "AWSCURRENT",
 		updateStage:   false,
 		keepStages:    10,
 		watchInterval: 5 * time.Second,
+		watchStrategy: watch.DefaultStrategy(),
 		quit:          make(chan bool),
 		l:             &log.EmptyLogger{},
+		sensitiveFunc: DefaultSensitiveKey,
 	}
 
 	for _, opt := range opts {
 		opt(p)
 	}
 
-	r := os.Getenv("AWS_REGION")
-	if r != "" {
-		p.region = r
+	return p
+}
+
+func (p *Provider) loadClient() error {
+	if p.clt != nil {
+		return nil
 	}
 
-	awsOpts := []func(*config.LoadOptions) error{
-		config.WithRegion(p.region),
+	awsCfg, err := p.loadAWSConfig()
+	if err != nil {
+		return err
 	}
 
+	// Create Secrets Manager client
+	p.clt = secretsmanager.NewFromConfig(awsCfg, func(o *secretsmanager.Options) {
+		if p.endpoint != "" {
+			o.BaseEndpoint = aws.String(p.endpoint)
+		}
+	})
+
+	return nil
+}
+
+// loadAWSConfig returns the AWS config of the client,
+// an explicit region takes precedence over the region of a secret ARN,
+// AWS_REGION and the shared config profile
+func (p *Provider) loadAWSConfig() (aws.Config, error) {
+	region := p.region
+	if region == "" && arn.IsARN(p.secretID) {
+		if a, err := arn.Parse(p.secretID); err == nil {
+			region = a.Region
+		}
+	}
+
+	var cred aws.CredentialsProvider
 	if p.accessKey != "" && p.secretKey != "" {
-		cred := aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(
+		cred = aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(
 			p.accessKey, p.secretKey, p.sessionToken))
+	}
+
+	if p.awsCfg != nil {
+		awsCfg := p.awsCfg.Copy()
+		if region != "" {
+			awsCfg.Region = region
+		}
+		if cred != nil {
+			awsCfg.Credentials = cred
+		}
+		if awsCfg.Region == "" {
+			awsCfg.Region = defaultRegion
+		}
+
+		return p.assumeRoleConfig(awsCfg), nil
+	}
+
+	awsOpts := make([]func(*config.LoadOptions) error, 0)
+
+	if region != "" {
+		awsOpts = append(awsOpts, config.WithRegion(region))
+	}
+
+	if p.profile != "" {
+		awsOpts = append(awsOpts, config.WithSharedConfigProfile(p.profile))
+	}
+
+	if cred != nil {
 		awsOpts = append(awsOpts, config.WithCredentialsProvider(cred))
 	}
 
 	awsCfg, err := config.LoadDefaultConfig(context.Background(), awsOpts...)
 	if err != nil {
-		return nil, fmt.Errorf("viperaws.secrets.NewConfigProvider: LoadDefaultConfig %s, %w",
+		return aws.Config{}, fmt.Errorf("viperaws.secrets.NewConfigProvider: LoadDefaultConfig %s, %w",
 			p.secretID, err)
 	}
 
-	// Create Secrets Manager client
-	p.clt = secretsmanager.NewFromConfig(awsCfg)
+	if awsCfg.Region == "" {
+		awsCfg.Region = defaultRegion
+	}
 
-	return p, nil
+	return p.assumeRoleConfig(awsCfg), nil
+}
+
+// assumeRoleConfig wraps the credentials of the AWS config in an auto-refreshing
+// assume role provider when WithAssumeRole is set
+func (p *Provider) assumeRoleConfig(awsCfg aws.Config) aws.Config {
+	if p.assumeRole == nil || p.assumeRole.RoleARN == "" {
+		return awsCfg
+	}
+
+	ar := p.assumeRole
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), ar.RoleARN,
+		func(o *stscreds.AssumeRoleOptions) {
+			if ar.ExternalID != "" {
+				o.ExternalID = aws.String(ar.ExternalID)
+			}
+			if ar.SessionName != "" {
+				o.RoleSessionName = ar.SessionName
+			}
+			if ar.Duration > 0 {
+				o.Duration = ar.Duration
+			}
+		})
+	awsCfg.Credentials = aws.NewCredentialsCache(provider)
+
+	return awsCfg
 }
 
 func (p *Provider) Name() string {
@@ -87,14 +222,41 @@ func (p *Provider) Name() string {
 }
 
 func (p *Provider) Get(rp viper.RemoteProvider) (io.Reader, error) {
+	bs, err := p.get(rp)
+	if err != nil {
+		if p.cache == nil {
+			return nil, err
+		}
+
+		bs, err = p.cache.Fallback(p.Name(), err)
+		if err != nil {
+			return nil, fmt.Errorf("viperaws.secrets.Provider.Get: %s, %w", p.secretID, err)
+		}
+
+		p.l.Warn("viperaws.secrets.Provider.Get: read the last known good value from the cache",
+			"secretID", p.secretID, "err", p.cache.Status().Err)
+	}
+
+	return bytes.NewReader(bs), nil
+}
+
+// get Get the value and remember the versions, the value is saved to the cache
+func (p *Provider) get(rp viper.RemoteProvider) ([]byte, error) {
 	result, err := p.GetResult(rp)
 	if err != nil {
 		return nil, err
 	}
 
-	p.versionId = *result.VersionId
+	bs, err := p.value(result)
+	if err != nil {
+		return nil, err
+	}
+
+	p.versionId = aws.ToString(result.VersionId)
+	p.doc = p.flatDocument(bs)
+	p.saveCache(bs, result)
 
-	return strings.NewReader(*result.SecretString), nil
+	return bs, nil
 }
 
 // GetResult Get the secret values, will also update the version stages
@@ -104,10 +266,15 @@ func (p *Provider) Get(rp viper.RemoteProvider) (io.Reader, error) {
 // Update the version stages: secretsmanager:ListSecretVersionIds,
 // secretsmanager:UpdateSecretVersionStage
 func (p *Provider) GetResult(_ viper.RemoteProvider) (*secretsmanager.GetSecretValueOutput, error) {
-	// VersionStage defaults to AWSCURRENT if unspecified
+	// VersionStage defaults to AWSCURRENT if unspecified,
+	// a pinned version ID takes precedence over the version stage
 	input := &secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(p.secretID),
-		VersionStage: aws.String("AWSCURRENT"),
+		SecretId: aws.String(p.secretID),
+	}
+	if p.pinVersionId != "" {
+		input.VersionId = aws.String(p.pinVersionId)
+	} else {
+		input.VersionStage = aws.String(p.versionStage)
 	}
 
 	// IAM policy: secretsmanager:GetSecretValue
@@ -119,7 +286,7 @@ func (p *Provider) GetResult(_ viper.RemoteProvider) (*secretsmanager.GetSecretV
 			p.secretID, err)
 	}
 
-	if result == nil || result.SecretString == nil || *result.SecretString == "" {
+	if isEmptyValue(result) {
 		return nil, fmt.Errorf("viperaws.secrets.Provider.GetResult: %s, %w",
 			p.secretID, ErrAwsSecretsEmptyValue)
 	}
@@ -167,15 +334,23 @@ func (p *Provider) cleanVersionStages() {
 		return cmp.Compare(b.CreatedDate.Unix(), a.CreatedDate.Unix())
 	})
 
-	// Keep current and previous
+	// Keep current and previous, and the pinned version
 	vs = slices.DeleteFunc(vs, func(v types.SecretVersionsListEntry) bool {
 		if len(v.VersionStages) == 0 {
 			return true
 		}
+		if p.pinVersionId != "" && v.VersionId != nil && *v.VersionId == p.pinVersionId {
+			return true
+		}
 		return slices.Contains(v.VersionStages, "AWSCURRENT") ||
-			slices.Contains(v.VersionStages, "AWSPREVIOUS")
+			slices.Contains(v.VersionStages, "AWSPREVIOUS") ||
+			slices.Contains(v.VersionStages, p.versionStage)
 	})
 
+	if len(vs) <= p.keepStages-2 {
+		return
+	}
+
 	vs = vs[p.keepStages-2:]
 
 	for _, v := range vs {
@@ -227,12 +402,11 @@ func (p *Provider) Watch(rp viper.RemoteProvider) (io.Reader, error) {
 }
 
 func (p *Provider) WatchChannel(rp viper.RemoteProvider) (<-chan *viper.RemoteResponse, chan bool) {
-	p.l.Info("viperaws.secrets.Provider.WatchChannel: start watching...", "secretID", p.secretID)
-
-	ticker := time.NewTicker(p.watchInterval)
+	p.l.Info("viperaws.secrets.Provider.WatchChannel: start watching...", "secretID", p.secretID,
+		"versionStage", p.versionStage, "versionId", p.pinVersionId)
 
 	ch := make(chan *viper.RemoteResponse)
-	quit := make(chan bool)
+	stopped := p.startWatch()
 
 	go func() {
 		defer func() {
@@ -242,39 +416,141 @@ func (p *Provider) WatchChannel(rp viper.RemoteProvider) (<-chan *viper.RemoteRe
 			}
 		}()
 
+		poller := watch.NewPoller(p.watchInterval, p.watchStrategy)
+		timer := time.NewTimer(poller.First())
+
 		for {
 			select {
-			case <-ticker.C:
-				out, err := p.GetResult(rp)
-				if err != nil {
-					p.l.Error("viperaws.secrets.Provider.WatchChannel",
+			case <-timer.C:
+				err := watch.Classify(p.poll(rp, ch))
+				d, stop := poller.Next(err)
+				switch {
+				case err == nil:
+				case stop:
+					p.l.Error("viperaws.secrets.Provider.WatchChannel: stop watching",
 						"secretID", p.secretID, "err", err)
-					continue
-				}
-				bs := []byte(*out.SecretString)
-
-				if p.versionId == *out.VersionId {
-					continue
-				}
-
-				p.versionId = *out.VersionId
-				ch <- &viper.RemoteResponse{
-					Value: bs,
-				}
-
-				if p.onChangeFunc != nil {
-					p.onChangeFunc(out)
+					p.stopWatch(stopped, err)
+					return
+				case err.Throttle:
+					p.l.Warn("viperaws.secrets.Provider.WatchChannel: throttled, backing off",
+						"secretID", p.secretID, "retryIn", d, "err", err)
+				default:
+					p.l.Error("viperaws.secrets.Provider.WatchChannel",
+						"secretID", p.secretID, "retryIn", d, "err", err)
 				}
+				timer.Reset(d)
 			case <-p.quit:
-				ticker.Stop()
+				timer.Stop()
 				return
 			}
 		}
 	}()
-	return ch, quit
+	return ch, p.quit
+}
+
+// poll sends the secret to the channel when its version changed
+func (p *Provider) poll(rp viper.RemoteProvider, ch chan<- *viper.RemoteResponse) error {
+	if p.describeCheck {
+		changed, err := p.changed()
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return nil
+		}
+	}
+
+	out, err := p.GetResult(rp)
+	if err != nil {
+		return err
+	}
+	if p.versionId == aws.ToString(out.VersionId) {
+		return nil
+	}
+
+	bs, err := p.value(out)
+	if err != nil {
+		return err
+	}
+
+	p.versionId = aws.ToString(out.VersionId)
+	p.saveCache(bs, out)
+
+	ch <- &viper.RemoteResponse{
+		Value: bs,
+	}
+
+	if p.onChangeFunc != nil {
+		p.onChangeFunc(out)
+	}
+
+	if p.onChangesFunc != nil {
+		m, err := p.parseDocument(bs)
+		if err != nil {
+			p.l.Warn("viperaws.secrets.Provider.WatchChannel: key-level changes",
+				"secretID", p.secretID, "err", err)
+			return nil
+		}
+		p.doc = notifyChanges(p.doc, m, map[string]string{aws.ToString(out.Name): p.versionId}, nil,
+			p.sensitiveFunc, p.onChangesFunc)
+	}
+
+	return nil
 }
 
 func (p *Provider) QuitWatch() {
 	p.l.Info("viperaws.secrets.Provider.QuitWatch", "secretID", p.secretID)
-	p.quit <- true
+	select {
+	case p.quit <- true:
+	case <-p.watchStopped():
+	}
+}
+
+// startWatch returns the stop signal of a new watcher, closed when it stops on an error of the watch policy
+func (p *Provider) startWatch() chan struct{} {
+	p.watchMu.Lock()
+	defer p.watchMu.Unlock()
+
+	p.stopped = make(chan struct{})
+
+	return p.stopped
+}
+
+// watchStopped returns the stop signal of the last watcher
+func (p *Provider) watchStopped() chan struct{} {
+	p.watchMu.Lock()
+	defer p.watchMu.Unlock()
+
+	return p.stopped
+}
+
+// stopWatch stops watching on an error of the watch policy
+func (p *Provider) stopWatch(stopped chan struct{}, err *watch.Error) {
+	close(stopped)
+
+	if p.onWatchStopFunc != nil {
+		p.onWatchStopFunc(err)
+	}
+}
+
+// saveCache saves the value and the version of the secret to the cache,
+// a failure only loses the last known good value
+func (p *Provider) saveCache(bs []byte, out *secretsmanager.GetSecretValueOutput) {
+	if p.cache == nil {
+		return
+	}
+
+	err := p.cache.Save(p.Name(), bs, map[string]string{aws.ToString(out.Name): p.versionId})
+	if err != nil {
+		p.l.Warn("viperaws.secrets.Provider.saveCache", "secretID", p.secretID, "err", err)
+	}
+}
+
+// CacheStatus returns the status of WithCache, stale when the value was read from the cache
+func (p *Provider) CacheStatus() cache.Status {
+	if p.cache == nil {
+		return cache.Status{}
+	}
+
+	return p.cache.Status()
 }
@@ -0,0 +1,984 @@
+package secrets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/smithy-go"
+	"github.com/spf13/viper"
+
+	"github.com/litsea/viper-aws/cache"
+	"github.com/litsea/viper-aws/fake"
+	"github.com/litsea/viper-aws/internal/testutil"
+	"github.com/litsea/viper-aws/log"
+	"github.com/litsea/viper-aws/watch"
+)
+
+// withTestInterval polls faster than the minimum interval of WithWatchInterval
+func withTestInterval(d time.Duration) Option {
+	return func(p *Provider) {
+		p.watchInterval = d
+	}
+}
+
+func newTestProvider(t *testing.T, sm *fake.SecretsManager, opts ...Option) *Provider {
+	t.Helper()
+
+	p, err := NewConfigProvider(append(opts, WithClient(sm), withTestInterval(10*time.Millisecond))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestProviderGet(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	vid := sm.PutSecretString("/app/test", `{"foo":"bar"}`)
+
+	p := newTestProvider(t, sm, WithSecretID("/app/test"))
+	r, err := p.Get(nil)
+
+	if got := testutil.ReadAll(t, r, err); got != `{"foo":"bar"}` {
+		t.Errorf("secret value: %s", got)
+	}
+	if p.versionId != vid {
+		t.Errorf("version ID: %s, expected %s", p.versionId, vid)
+	}
+}
+
+func TestProviderGetBinary(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte("foo: bar\n"))
+	_ = zw.Close()
+
+	tests := []struct {
+		name     string
+		value    []byte
+		decoding BinaryDecoding
+		want     string
+		err      error
+	}{
+		{"raw", []byte("foo: bar\n"), BinaryRaw, "foo: bar\n", nil},
+		{"gzip", buf.Bytes(), BinaryGzip, "foo: bar\n", nil},
+		{"base64", []byte("Zm9vOiBiYXIK\n"), BinaryBase64, "foo: bar\n", nil},
+		{"unknown", []byte("foo: bar\n"), BinaryDecoding(9), "", ErrAwsSecretsBinaryDecoding},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := fake.NewSecretsManager()
+			sm.PutSecretBinary("/app/test", tt.value)
+
+			p := newTestProvider(t, sm, WithSecretID("/app/test"), WithBinaryDecoding(tt.decoding))
+			r, err := p.Get(nil)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("error: %v, expected %v", err, tt.err)
+				}
+				return
+			}
+
+			if got := testutil.ReadAll(t, r, err); got != tt.want {
+				t.Errorf("decoded secret binary: %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderWatchBinary(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretBinary("/app/test", []byte(`{"foo":"v1"}`))
+
+	p := newTestProvider(t, sm, WithSecretID("/app/test"))
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vid := sm.PutSecretBinary("/app/test", []byte(`{"foo":"v2"}`))
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	if got := testutil.Receive(t, ch); got != `{"foo":"v2"}` {
+		t.Errorf("watched secret binary: %s", got)
+	}
+	if p.versionId != vid {
+		t.Errorf("version ID: %s, expected %s", p.versionId, vid)
+	}
+}
+
+// namelessClient returns GetSecretValue outputs without the secret name, like a custom client may do
+type namelessClient struct {
+	*fake.SecretsManager
+}
+
+func (c namelessClient) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput,
+	optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.GetSecretValueOutput, error) {
+	out, err := c.SecretsManager.GetSecretValue(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	out.Name = nil
+
+	return out, nil
+}
+
+func TestProviderGetWithoutName(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app/test", `{"foo":"v1"}`)
+
+	p, err := NewConfigProvider(WithClient(namelessClient{sm}), WithSecretID("/app/test"),
+		withTestInterval(10*time.Millisecond), WithOnChangesFunc(func(*Changes) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := p.Get(nil)
+	if got := testutil.ReadAll(t, r, err); got != `{"foo":"v1"}` {
+		t.Errorf("secret value: %s", got)
+	}
+
+	sm.PutSecretString("/app/test", `{"foo":"v2"}`)
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	if got := testutil.Receive(t, ch); got != `{"foo":"v2"}` {
+		t.Errorf("watched secret value: %s", got)
+	}
+}
+
+func TestProviderVersionStage(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app/test", `{"foo":"current"}`)
+	sm.PutSecretString("/app/test", `{"foo":"pending"}`, "AWSPENDING")
+
+	p := newTestProvider(t, sm, WithSecretID("/app/test"), WithVersionStage("AWSPENDING"))
+	r, err := p.Get(nil)
+
+	if got := testutil.ReadAll(t, r, err); got != `{"foo":"pending"}` {
+		t.Errorf("secret value at AWSPENDING: %s", got)
+	}
+}
+
+func TestProviderVersionID(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	vid := sm.PutSecretString("/app/test", `{"foo":"v1"}`)
+	sm.PutSecretString("/app/test", `{"foo":"v2"}`)
+
+	p := newTestProvider(t, sm, WithSecretID("/app/test"), WithVersionID(vid), WithVersionStage("AWSCURRENT"))
+	r, err := p.Get(nil)
+
+	if got := testutil.ReadAll(t, r, err); got != `{"foo":"v1"}` {
+		t.Errorf("pinned secret value: %s", got)
+	}
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	// A pinned version never changes
+	sm.PutSecretString("/app/test", `{"foo":"v3"}`)
+
+	select {
+	case resp := <-ch:
+		t.Errorf("received %s for a pinned version", resp.Value)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestProviderWatchVersionStage(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app/test", `{"foo":"current"}`)
+	sm.PutSecretString("/app/test", `{"foo":"pending1"}`, "AWSPENDING")
+
+	p := newTestProvider(t, sm, WithSecretID("/app/test"), WithVersionStage("AWSPENDING"))
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	// A new AWSCURRENT version is not watched
+	sm.PutSecretString("/app/test", `{"foo":"current2"}`)
+	sm.PutSecretString("/app/test", `{"foo":"pending2"}`, "AWSPENDING")
+
+	if got := testutil.Receive(t, ch); got != `{"foo":"pending2"}` {
+		t.Errorf("watched secret value at AWSPENDING: %s", got)
+	}
+}
+
+func TestProviderCleanVersionStages(t *testing.T) {
+	tests := []struct {
+		name string
+		// stages of the versions, oldest first, the version with the pin stage is pinned
+		stages [][]string
+		// expected UpdateSecretVersionStage calls, the new date stage included
+		updates int
+	}{
+		{"too few versions", [][]string{{"a"}, {"AWSPENDING"}, {"AWSCURRENT"}}, 1},
+		{
+			"kept stages only",
+			[][]string{{"a"}, {"AWSPENDING"}, {"pin"}, {"AWSCURRENT"}, {"AWSCURRENT"}},
+			1,
+		},
+		{
+			"old stages",
+			[][]string{{"a"}, {"b"}, {"c"}, {"AWSPENDING"}, {"AWSCURRENT"}, {"AWSCURRENT"}},
+			2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := fake.NewSecretsManager()
+
+			opts := []Option{
+				WithSecretID("/app/test"), WithUpdateStage(true), WithKeepStages(4),
+				WithVersionStage("AWSPENDING"),
+			}
+			for i, stgs := range tt.stages {
+				vid := sm.PutSecretString("/app/test", fmt.Sprintf(`{"foo":%d}`, i), stgs...)
+				if slices.Contains(stgs, "pin") {
+					opts = append(opts, WithVersionID(vid))
+				}
+			}
+
+			p := newTestProvider(t, sm, opts...)
+			_, err := p.Get(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n := sm.Calls("UpdateSecretVersionStage"); n != tt.updates {
+				t.Errorf("UpdateSecretVersionStage calls: %d, expected %d", n, tt.updates)
+			}
+		})
+	}
+}
+
+func TestProviderWatchChannelDescribeCheck(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app/test", `{"foo":"v1"}`)
+
+	p := newTestProvider(t, sm, WithSecretID("/app/test"), WithDescribeCheck(true))
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	time.Sleep(50 * time.Millisecond)
+	sm.PutSecretString("/app/test", `{"foo":"v2"}`)
+
+	if got := testutil.Receive(t, ch); got != `{"foo":"v2"}` {
+		t.Errorf("watched secret value: %s", got)
+	}
+	if p.SkippedFetches() == 0 {
+		t.Error("no GetSecretValue calls were skipped by DescribeSecret checks")
+	}
+	if n := sm.Calls("GetSecretValue"); n != 2 {
+		t.Errorf("GetSecretValue calls: %d, expected 2", n)
+	}
+}
+
+func TestProviderWatchQuit(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app/test", `{"foo":"v1"}`)
+
+	p := newTestProvider(t, sm, WithSecretID("/app/test"))
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, quit := p.WatchChannel(nil)
+
+	// The returned quit channel stops the watcher
+	select {
+	case quit <- true:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the watcher does not read the quit channel")
+	}
+
+	sm.PutSecretString("/app/test", `{"foo":"v2"}`)
+
+	select {
+	case resp := <-ch:
+		t.Errorf("received %s after quit", resp.Value)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMultiProvider(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/org/common", `{"log":{"level":"info","format":"json"},"region":"us"}`)
+	sm.PutSecretString("/app/prod", `{"log":{"level":"warn"}}`)
+
+	mp, err := NewMultiConfigProvider([]string{"/org/common", "/app/prod"}, WithClient(sm),
+		withTestInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := mp.Get(nil)
+	want := `{"log":{"format":"json","level":"warn"},"region":"us"}`
+	if got := testutil.ReadAll(t, r, err); got != want {
+		t.Errorf("merged secrets: %s, expected %s", got, want)
+	}
+
+	ch, quit := mp.WatchChannel(nil)
+
+	sm.PutSecretString("/org/common", `{"log":{"level":"info","format":"text"},"region":"us"}`)
+
+	want = `{"log":{"format":"text","level":"warn"},"region":"us"}`
+	if got := testutil.Receive(t, ch); got != want {
+		t.Errorf("watched merged secrets: %s, expected %s", got, want)
+	}
+
+	// The returned quit channel stops the watcher
+	select {
+	case quit <- true:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the watcher does not read the quit channel")
+	}
+}
+
+func TestMultiProviderDescribeCheck(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/org/common", `{"region":"us"}`)
+	sm.PutSecretString("/app/prod", `{"debug":false}`)
+
+	mp, err := NewMultiConfigProvider([]string{"/org/common", "/app/prod"}, WithClient(sm),
+		withTestInterval(10*time.Millisecond), WithDescribeCheck(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mp.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm.PutSecretString("/app/prod", `{"debug":true}`)
+
+	ch, _ := mp.WatchChannel(nil)
+	defer mp.QuitWatch()
+
+	if got := testutil.Receive(t, ch); got != `{"debug":true,"region":"us"}` {
+		t.Errorf("watched merged secrets: %s", got)
+	}
+	if mp.SkippedFetches() == 0 {
+		t.Error("no GetSecretValue calls were skipped by DescribeSecret checks")
+	}
+	// Only the changed secret is read again
+	if n := sm.Calls("GetSecretValue"); n != 3 {
+		t.Errorf("GetSecretValue calls: %d, expected 3", n)
+	}
+}
+
+func TestBatchProvider(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app-a/prod/db", `{"host":"db.local"}`)
+	sm.PutSecretString("/app-a/prod/api/token", "t0k3n")
+	sm.PutSecretString("/app-b/prod/db", `{"host":"other"}`)
+
+	bp, err := NewBatchConfigProvider(nil, WithClient(sm), WithBatchFilter("name", "/app-a/prod/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := bp.Get(nil)
+	want := `{"api":{"token":"t0k3n"},"db":{"host":"db.local"}}`
+	if got := testutil.ReadAll(t, r, err); got != want {
+		t.Errorf("batch secrets: %s, expected %s", got, want)
+	}
+}
+
+func TestBatchProviderIDs(t *testing.T) {
+	sm := fake.NewSecretsManager()
+
+	// More IDs than one BatchGetSecretValue call accepts
+	ids := make([]string, 0, 25)
+	for i := range 25 {
+		id := fmt.Sprintf("/app-a/prod/s%02d", i)
+		sm.PutSecretString(id, fmt.Sprintf(`{"n":%d}`, i))
+		ids = append(ids, id)
+	}
+
+	bp, err := NewBatchConfigProvider(ids, WithClient(sm))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := bp.Get(nil)
+	v := viper.New()
+	v.SetConfigType("json")
+	err = v.ReadConfig(strings.NewReader(testutil.ReadAll(t, r, err)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := v.GetInt("app-a.prod.s24.n"); got != 24 {
+		t.Errorf("app-a.prod.s24.n: %d, expected 24", got)
+	}
+	if n := sm.Calls("BatchGetSecretValue"); n != 2 {
+		t.Errorf("BatchGetSecretValue calls: %d, expected 2", n)
+	}
+}
+
+func TestNewBatchConfigProviderInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  []string
+		opts []Option
+		err  error
+	}{
+		{"empty", nil, nil, ErrAwsSecretsIDsEmpty},
+		{"ids and filters", []string{"/app-a/prod/db"},
+			[]Option{WithBatchFilter("name", "/app-a/prod/")}, ErrAwsSecretsBatchIDsAndFilters},
+		{"version stage", []string{"/app-a/prod/db"},
+			[]Option{WithVersionStage("AWSPENDING")}, ErrAwsSecretsBatchOptions},
+		{"version ID", []string{"/app-a/prod/db"},
+			[]Option{WithVersionID("v1")}, ErrAwsSecretsBatchOptions},
+		{"update stage", []string{"/app-a/prod/db"},
+			[]Option{WithUpdateStage(true)}, ErrAwsSecretsBatchOptions},
+		{"describe check", []string{"/app-a/prod/db"},
+			[]Option{WithDescribeCheck(true)}, ErrAwsSecretsBatchOptions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewBatchConfigProvider(tt.ids, append(tt.opts, WithClient(fake.NewSecretsManager()))...)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("error: %v, expected %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestBatchProviderKeyConflict(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app-a/prod/db", `{"host":"db.local"}`)
+	sm.PutSecretString("/app-a/prod/db/replica", `{"host":"replica.local"}`)
+
+	bp, err := NewBatchConfigProvider(nil, WithClient(sm), WithBatchFilter("name", "/app-a/prod/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = bp.Get(nil)
+	if !errors.Is(err, ErrAwsSecretsBatchKeyConflict) ||
+		!strings.Contains(err.Error(), "/app-a/prod/db (db) and /app-a/prod/db/replica (db.replica)") {
+		t.Errorf("key conflict error: %v", err)
+	}
+}
+
+func TestBatchProviderDeleted(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app-a/prod/db", `{"host":"db.local"}`)
+	sm.PutSecretString("/app-a/prod/api/token", "t0k3n")
+
+	changed := make(chan *Changes, 1)
+	bp, err := NewBatchConfigProvider(nil, WithClient(sm), withTestInterval(10*time.Millisecond),
+		WithBatchFilter("name", "/app-a/prod/"),
+		WithOnChangesFunc(func(changes *Changes) {
+			changed <- changes
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = bp.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, quit := bp.WatchChannel(nil)
+
+	sm.DeleteSecret("/app-a/prod/api/token")
+
+	if got := testutil.Receive(t, ch); got != `{"db":{"host":"db.local"}}` {
+		t.Errorf("watched secrets: %s", got)
+	}
+
+	changes := <-changed
+	if !slices.Equal(changes.DeletedSecrets, []string{"/app-a/prod/api/token"}) ||
+		!slices.Equal(changes.Deleted, []string{"api.token"}) {
+		t.Errorf("changes: %+v", changes)
+	}
+
+	// The returned quit channel stops the watcher
+	select {
+	case quit <- true:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the watcher does not read the quit channel")
+	}
+}
+
+func TestProviderRegion(t *testing.T) {
+	t.Setenv("AWS_REGION", "eu-west-1")
+
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"env", nil, "eu-west-1"},
+		{"explicit", []Option{WithRegion("ap-northeast-1")}, "ap-northeast-1"},
+		{"aws config", []Option{WithAWSConfig(aws.Config{Region: "us-west-2"})}, "us-west-2"},
+		{"explicit over aws config", []Option{
+			WithAWSConfig(aws.Config{Region: "us-west-2"}), WithRegion("ap-northeast-1"),
+		}, "ap-northeast-1"},
+		{"secret arn", []Option{
+			WithSecretID("arn:aws:secretsmanager:sa-east-1:210987654321:secret:/platform/app-AbCdEf"),
+		}, "sa-east-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProvider(append(tt.opts, WithAccessKey("test"), WithSecretKey("test"))...)
+			awsCfg, err := p.loadAWSConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if awsCfg.Region != tt.want {
+				t.Errorf("region: %s, expected %s", awsCfg.Region, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderAssumeRole(t *testing.T) {
+	p := newProvider(
+		WithRegion("us-east-1"),
+		WithAccessKey("test"),
+		WithSecretKey("test"),
+		WithAssumeRole(AssumeRole{
+			RoleARN:     "arn:aws:iam::210987654321:role/config-reader",
+			ExternalID:  "app-a",
+			SessionName: "app-a-config",
+		}),
+	)
+
+	awsCfg, err := p.loadAWSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc, ok := awsCfg.Credentials.(*aws.CredentialsCache)
+	if !ok || !cc.IsCredentialsProvider(&stscreds.AssumeRoleProvider{}) {
+		t.Errorf("credentials: %T, expected a cached stscreds.AssumeRoleProvider", awsCfg.Credentials)
+	}
+}
+
+func TestProviderOnChangesFunc(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app/test", `{"db":{"host":"a","password":"p1"},"debug":true}`)
+
+	changed := make(chan *Changes, 1)
+	p := newTestProvider(t, sm, WithSecretID("/app/test"), WithOnChangesFunc(func(changes *Changes) {
+		changed <- changes
+	}))
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vid := sm.PutSecretString("/app/test", `{"db":{"host":"b","password":"p2"},"level":"info"}`)
+
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+	testutil.Receive(t, ch)
+
+	changes := <-changed
+	if !slices.Equal(changes.Updated, []string{"db.host", "db.password"}) ||
+		!slices.Equal(changes.Created, []string{"level"}) ||
+		!slices.Equal(changes.Deleted, []string{"debug"}) {
+		t.Errorf("changes: %+v", changes)
+	}
+
+	if changes.Old["db.host"] != "a" || changes.New["db.host"] != "b" {
+		t.Errorf("db.host values: %v -> %v", changes.Old["db.host"], changes.New["db.host"])
+	}
+	if _, ok := changes.New["db.password"]; ok {
+		t.Error("sensitive value db.password is in the change set")
+	}
+	if changes.VersionIds["/app/test"] != vid {
+		t.Errorf("version IDs: %v, expected %s", changes.VersionIds, vid)
+	}
+}
+
+func TestDefaultSensitiveKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"db.password", true},
+		{"db.dsn", true},
+		{"api_key", true},
+		{"apiKey", true},
+		{"stripe.secret_key", true},
+		{"auth-token", true},
+		{"hash.salt", true},
+		{"db_password", true},
+		{"dbPassword", true},
+		{"oauth.client_secret", true},
+		{"tls.private_key", true},
+		{"monkey", false},
+		{"keyspace", false},
+		{"cache_key_prefix", false},
+		{"db.host", false},
+		{"basalt", false},
+		{"tokenizer.enabled", false},
+		{"private_network.cidr", false},
+		{"secretary.email", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := DefaultSensitiveKey(tt.key); got != tt.want {
+				t.Errorf("DefaultSensitiveKey(%q): %v, expected %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+// throttlingClient throttles the first calls of GetSecretValue
+type throttlingClient struct {
+	*fake.SecretsManager
+	throttles atomic.Int32
+}
+
+func (c *throttlingClient) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput,
+	optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.GetSecretValueOutput, error) {
+	if c.throttles.Add(-1) >= 0 {
+		return nil, &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+	}
+
+	return c.SecretsManager.GetSecretValue(ctx, params, optFns...)
+}
+
+func (c *throttlingClient) BatchGetSecretValue(ctx context.Context, params *secretsmanager.BatchGetSecretValueInput,
+	optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.BatchGetSecretValueOutput, error) {
+	if c.throttles.Add(-1) >= 0 {
+		return nil, &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+	}
+
+	return c.SecretsManager.BatchGetSecretValue(ctx, params, optFns...)
+}
+
+type recordLogger struct {
+	log.EmptyLogger
+	mu     sync.Mutex
+	warns  []string
+	delays []time.Duration
+}
+
+func (l *recordLogger) Warn(msg string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.warns = append(l.warns, msg)
+	for _, v := range args {
+		if d, ok := v.(time.Duration); ok {
+			l.delays = append(l.delays, d)
+		}
+	}
+}
+
+// watcher is the watching part of the providers
+type watcher interface {
+	Get(rp viper.RemoteProvider) (io.Reader, error)
+	WatchChannel(rp viper.RemoteProvider) (<-chan *viper.RemoteResponse, chan bool)
+	QuitWatch()
+}
+
+func TestProviderWatchThrottled(t *testing.T) {
+	tests := []struct {
+		name  string
+		newFn func(opts ...Option) (watcher, error)
+		want  string
+	}{
+		{
+			name: "provider",
+			newFn: func(opts ...Option) (watcher, error) {
+				return NewConfigProvider(append(opts, WithSecretID("/app/test"))...)
+			},
+			want: `{"foo":"baz"}`,
+		},
+		{
+			name: "multi",
+			newFn: func(opts ...Option) (watcher, error) {
+				return NewMultiConfigProvider([]string{"/app/test"}, opts...)
+			},
+			want: `{"foo":"baz"}`,
+		},
+		{
+			name: "batch",
+			newFn: func(opts ...Option) (watcher, error) {
+				return NewBatchConfigProvider([]string{"/app/test"}, opts...)
+			},
+			want: `{"app":{"test":{"foo":"baz"}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := fake.NewSecretsManager()
+			sm.PutSecretString("/app/test", `{"foo":"bar"}`)
+
+			c := &throttlingClient{SecretsManager: sm}
+			l := &recordLogger{}
+			p, err := tt.newFn(WithClient(c), withTestInterval(10*time.Millisecond), WithLogger(l),
+				WithWatchStrategy(watch.Strategy{Multiplier: 2, MaxInterval: time.Second}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = p.Get(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.throttles.Store(3)
+			sm.PutSecretString("/app/test", `{"foo":"baz"}`)
+
+			ch, _ := p.WatchChannel(nil)
+			defer p.QuitWatch()
+
+			if got := testutil.Receive(t, ch); got != tt.want {
+				t.Errorf("watched secret: %s, expected %s", got, tt.want)
+			}
+
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			if len(l.warns) != 3 {
+				t.Errorf("warnings: %v, expected 3 throttling warnings", l.warns)
+			}
+
+			// The interval doubles on every throttling
+			want := []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 80 * time.Millisecond}
+			if !slices.Equal(l.delays, want) {
+				t.Errorf("delays: %v, expected %v", l.delays, want)
+			}
+		})
+	}
+}
+
+func TestProviderWatchStop(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app/test", `{"foo":"bar"}`)
+
+	stopped := make(chan *watch.Error, 1)
+	p := newTestProvider(t, sm, WithSecretID("/app/test"),
+		WithWatchStrategy(watch.Strategy{Policy: watch.Policy{Permanent: watch.ActionStop}}),
+		WithOnWatchStopFunc(func(err *watch.Error) {
+			stopped <- err
+		}))
+
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitStop := func() {
+		t.Helper()
+
+		select {
+		case err := <-stopped:
+			if !errors.Is(err, watch.ErrPermanent) || err.Code != "ResourceNotFoundException" {
+				t.Errorf("error: %v, %s", err, err.Code)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("the watcher did not stop")
+		}
+	}
+
+	sm.DeleteSecret("/app/test")
+	p.WatchChannel(nil)
+	waitStop()
+
+	// Does not block once the watcher stopped
+	p.QuitWatch()
+
+	// A new watcher stops on the policy again, without reusing the closed stop signal
+	p.WatchChannel(nil)
+	waitStop()
+
+	// QuitWatch stops a watcher started after a policy stop
+	sm.PutSecretString("/app/test", `{"foo":"baz"}`)
+	ch, _ := p.WatchChannel(nil)
+	if got := testutil.Receive(t, ch); got != `{"foo":"baz"}` {
+		t.Errorf("watched secret: %s", got)
+	}
+
+	p.QuitWatch()
+	sm.PutSecretString("/app/test", `{"foo":"qux"}`)
+
+	select {
+	case resp := <-ch:
+		t.Errorf("received %s after QuitWatch", resp.Value)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestProviderCache(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app/test", `{"foo":"bar"}`)
+
+	path := filepath.Join(t.TempDir(), "app.cache")
+	p := newTestProvider(t, sm, WithSecretID("/app/test"), WithCache(testutil.NewCache(t, path)))
+	_, err := p.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// AWS fails at startup, the last known good value is read from the cache
+	c := &throttlingClient{SecretsManager: sm}
+	c.throttles.Store(1)
+	p, err = NewConfigProvider(WithSecretID("/app/test"), WithClient(c), withTestInterval(10*time.Millisecond),
+		WithCache(testutil.NewCache(t, path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := p.Get(nil)
+	if got := testutil.ReadAll(t, r, err); got != `{"foo":"bar"}` {
+		t.Errorf("cached secret: %s", got)
+	}
+	if s := p.CacheStatus(); !s.Stale || s.Err == nil {
+		t.Errorf("status: %+v, expected stale", s)
+	}
+
+	// The value cached for another secret is not read
+	other := newTestProvider(t, sm, WithSecretID("/app/other"), WithCache(testutil.NewCache(t, path)))
+	_, err = other.Get(nil)
+	if !errors.Is(err, cache.ErrCacheName) {
+		t.Errorf("error: %v, expected cache.ErrCacheName", err)
+	}
+
+	// The watcher refreshes the value once AWS is reachable
+	sm.PutSecretString("/app/test", `{"foo":"baz"}`)
+	ch, _ := p.WatchChannel(nil)
+	defer p.QuitWatch()
+
+	if got := testutil.Receive(t, ch); got != `{"foo":"baz"}` {
+		t.Errorf("watched secret: %s", got)
+	}
+	if s := p.CacheStatus(); s.Stale {
+		t.Errorf("status: %+v, expected fresh", s)
+	}
+}
+
+func TestMultiProviderCache(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/org/common", `{"region":"us"}`)
+	sm.PutSecretString("/app/prod", `{"level":"warn"}`)
+
+	path := filepath.Join(t.TempDir(), "app.cache")
+	ids := []string{"/org/common", "/app/prod"}
+
+	mp, err := NewMultiConfigProvider(ids, WithClient(sm), WithCache(testutil.NewCache(t, path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mp.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &throttlingClient{SecretsManager: sm}
+	c.throttles.Store(1)
+	mp, err = NewMultiConfigProvider(ids, WithClient(c), withTestInterval(10*time.Millisecond),
+		WithCache(testutil.NewCache(t, path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := mp.Get(nil)
+	if got := testutil.ReadAll(t, r, err); got != `{"level":"warn","region":"us"}` {
+		t.Errorf("cached secrets: %s", got)
+	}
+	if st := mp.CacheStatus(); !st.Stale || len(st.Versions) != 2 {
+		t.Errorf("status: %+v, expected stale", st)
+	}
+
+	sm.PutSecretString("/app/prod", `{"level":"info"}`)
+	ch, _ := mp.WatchChannel(nil)
+	defer mp.QuitWatch()
+
+	if got := testutil.Receive(t, ch); got != `{"level":"info","region":"us"}` {
+		t.Errorf("watched secrets: %s", got)
+	}
+	if st := mp.CacheStatus(); st.Stale {
+		t.Errorf("status: %+v, expected fresh", st)
+	}
+}
+
+func TestBatchProviderCache(t *testing.T) {
+	sm := fake.NewSecretsManager()
+	sm.PutSecretString("/app-a/prod/db", `{"host":"db.local"}`)
+
+	path := filepath.Join(t.TempDir(), "app.cache")
+
+	bp, err := NewBatchConfigProvider([]string{"/app-a/prod/db"}, WithClient(sm), WithCache(testutil.NewCache(t, path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = bp.Get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &throttlingClient{SecretsManager: sm}
+	c.throttles.Store(1)
+	bp, err = NewBatchConfigProvider([]string{"/app-a/prod/db"}, WithClient(c), withTestInterval(10*time.Millisecond),
+		WithCache(testutil.NewCache(t, path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := bp.Get(nil)
+	if got := testutil.ReadAll(t, r, err); got != `{"app-a":{"prod":{"db":{"host":"db.local"}}}}` {
+		t.Errorf("cached secrets: %s", got)
+	}
+	if st := bp.CacheStatus(); !st.Stale {
+		t.Errorf("status: %+v, expected stale", st)
+	}
+
+	sm.PutSecretString("/app-a/prod/db", `{"host":"db2.local"}`)
+	ch, _ := bp.WatchChannel(nil)
+	defer bp.QuitWatch()
+
+	if got := testutil.Receive(t, ch); got != `{"app-a":{"prod":{"db":{"host":"db2.local"}}}}` {
+		t.Errorf("watched secrets: %s", got)
+	}
+	if st := bp.CacheStatus(); st.Stale {
+		t.Errorf("status: %+v, expected fresh", st)
+	}
+}
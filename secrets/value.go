@@ -0,0 +1,136 @@
+package secrets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+
+	"github.com/litsea/viper-aws/internal/jsonmap"
+)
+
+var ErrAwsSecretsBinaryDecoding = errors.New("AWS Secrets binary decoding is unknown")
+
+// BinaryDecoding is how a SecretBinary payload is decoded before it reaches viper
+type BinaryDecoding int
+
+const (
+	// BinaryRaw passes the SecretBinary bytes through unchanged
+	BinaryRaw BinaryDecoding = iota
+	// BinaryGzip gunzips the SecretBinary bytes
+	BinaryGzip
+	// BinaryBase64 decodes SecretBinary bytes holding standard base64 text
+	BinaryBase64
+)
+
+func (d BinaryDecoding) String() string {
+	switch d {
+	case BinaryRaw:
+		return "raw"
+	case BinaryGzip:
+		return "gzip"
+	case BinaryBase64:
+		return "base64"
+	default:
+		return fmt.Sprintf("BinaryDecoding(%d)", int(d))
+	}
+}
+
+func (d BinaryDecoding) decode(bs []byte) ([]byte, error) {
+	switch d {
+	case BinaryRaw:
+		return bs, nil
+	case BinaryGzip:
+		r, err := gzip.NewReader(bytes.NewReader(bs))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return io.ReadAll(r)
+	case BinaryBase64:
+		out := make([]byte, base64.StdEncoding.DecodedLen(len(bs)))
+		n, err := base64.StdEncoding.Decode(out, bytes.TrimSpace(bs))
+		if err != nil {
+			return nil, err
+		}
+
+		return out[:n], nil
+	default:
+		return nil, fmt.Errorf("%s, %w", d, ErrAwsSecretsBinaryDecoding)
+	}
+}
+
+// isEmptyValue reports whether neither SecretString nor SecretBinary holds a value
+func isEmptyValue(out *secretsmanager.GetSecretValueOutput) bool {
+	if out == nil {
+		return true
+	}
+
+	return (out.SecretString == nil || *out.SecretString == "") && len(out.SecretBinary) == 0
+}
+
+// value returns the secret payload fed to viper,
+// SecretString takes precedence over SecretBinary
+func (p *Provider) value(out *secretsmanager.GetSecretValueOutput) ([]byte, error) {
+	if out.SecretString != nil && *out.SecretString != "" {
+		return []byte(*out.SecretString), nil
+	}
+
+	bs, err := p.binaryDecoding.decode(out.SecretBinary)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.secrets.Provider.value: decode SecretBinary %s (%s), %w",
+			p.secretID, p.binaryDecoding, err)
+	}
+
+	if len(bs) == 0 {
+		return nil, fmt.Errorf("viperaws.secrets.Provider.value: %s, %w",
+			p.secretID, ErrAwsSecretsEmptyValue)
+	}
+
+	return bs, nil
+}
+
+// document returns the secret payload decoded as a JSON object
+func (p *Provider) document(out *secretsmanager.GetSecretValueOutput) (map[string]any, error) {
+	bs, err := p.value(out)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parseDocument(bs)
+}
+
+// parseDocument decodes the secret payload returned by value as a JSON object
+func (p *Provider) parseDocument(bs []byte) (map[string]any, error) {
+	var m map[string]any
+	err := json.Unmarshal(bs, &m)
+	if err != nil {
+		return nil, fmt.Errorf("viperaws.secrets.Provider.parseDocument: json.Unmarshal %s, %w",
+			p.secretID, err)
+	}
+
+	return m, nil
+}
+
+// flatDocument returns the flattened JSON document of the secret payload for key-level changes,
+// nil if WithOnChangesFunc is not set or the secret is not a JSON object
+func (p *Provider) flatDocument(bs []byte) map[string]any {
+	if p.onChangesFunc == nil {
+		return nil
+	}
+
+	m, err := p.parseDocument(bs)
+	if err != nil {
+		p.l.Warn("viperaws.secrets.Provider.flatDocument: key-level changes",
+			"secretID", p.secretID, "err", err)
+		return nil
+	}
+
+	return jsonmap.Flatten(m)
+}
@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+func TestProviderValue(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte("foo: bar\n"))
+	_ = zw.Close()
+
+	tests := []struct {
+		name     string
+		out      *secretsmanager.GetSecretValueOutput
+		decoding BinaryDecoding
+		want     string
+		err      error
+	}{
+		{"string", &secretsmanager.GetSecretValueOutput{SecretString: aws.String("foo: bar\n")},
+			BinaryGzip, "foo: bar\n", nil},
+		{"string over binary", &secretsmanager.GetSecretValueOutput{
+			SecretString: aws.String("foo: bar\n"), SecretBinary: []byte("foo: baz\n"),
+		}, BinaryRaw, "foo: bar\n", nil},
+		{"raw", &secretsmanager.GetSecretValueOutput{SecretBinary: []byte("foo: bar\n")},
+			BinaryRaw, "foo: bar\n", nil},
+		{"gzip", &secretsmanager.GetSecretValueOutput{SecretBinary: buf.Bytes()},
+			BinaryGzip, "foo: bar\n", nil},
+		{"base64", &secretsmanager.GetSecretValueOutput{SecretBinary: []byte("Zm9vOiBiYXIK\n")},
+			BinaryBase64, "foo: bar\n", nil},
+		{"invalid gzip", &secretsmanager.GetSecretValueOutput{SecretBinary: []byte("foo: bar, not gzipped\n")},
+			BinaryGzip, "", gzip.ErrHeader},
+		{"unknown", &secretsmanager.GetSecretValueOutput{SecretBinary: []byte("foo: bar\n")},
+			BinaryDecoding(9), "", ErrAwsSecretsBinaryDecoding},
+		{"empty", &secretsmanager.GetSecretValueOutput{SecretBinary: []byte{}},
+			BinaryRaw, "", ErrAwsSecretsEmptyValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Provider{secretID: "/app/test", binaryDecoding: tt.decoding}
+			bs, err := p.value(tt.out)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("error: %v, expected %v", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(bs) != tt.want {
+				t.Errorf("value: %q, expected %q", bs, tt.want)
+			}
+		})
+	}
+}
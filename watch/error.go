@@ -0,0 +1,121 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/aws/smithy-go"
+)
+
+// Category is the category of a watch error
+type Category int
+
+const (
+	// CategoryTransient errors may succeed on a later poll, e.g. throttling, timeouts and 5xx responses
+	CategoryTransient Category = iota
+	// CategoryPermanent errors need a change of the config or the options, e.g. a deleted secret
+	CategoryPermanent
+	// CategoryAuth errors need a change of the credentials or the IAM policies, e.g. AccessDeniedException
+	CategoryAuth
+)
+
+var (
+	ErrTransient = errors.New("transient watch error")
+	ErrPermanent = errors.New("permanent watch error")
+	ErrAuth      = errors.New("auth watch error")
+)
+
+func (c Category) String() string {
+	switch c {
+	case CategoryPermanent:
+		return "permanent"
+	case CategoryAuth:
+		return "auth"
+	default:
+		return "transient"
+	}
+}
+
+func (c Category) sentinel() error {
+	switch c {
+	case CategoryPermanent:
+		return ErrPermanent
+	case CategoryAuth:
+		return ErrAuth
+	default:
+		return ErrTransient
+	}
+}
+
+// authCodes are the error codes of invalid credentials and denied access
+var authCodes = []string{
+	"AccessDenied",
+	"AccessDeniedException",
+	"ExpiredToken",
+	"ExpiredTokenException",
+	"IncompleteSignature",
+	"InvalidClientTokenId",
+	"InvalidSignatureException",
+	"MissingAuthenticationToken",
+	"NotAuthorized",
+	"SignatureDoesNotMatch",
+	"UnrecognizedClientException",
+	"KMSAccessDeniedException",
+}
+
+// Error is a classified error of a watcher, errors.Is matches ErrTransient, ErrPermanent or ErrAuth
+type Error struct {
+	Category Category
+	// Code is the AWS error code, e.g. ResourceNotFoundException
+	Code string
+	// Throttle is set when the AWS API throttled the request
+	Throttle bool
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return e.Category.String() + " watch error: " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Category.sentinel()
+}
+
+// Classify returns the classified error, nil when err is nil.
+// Throttling, retryable and server errors are transient, denied access and invalid credentials are auth
+// errors, any other error is permanent, e.g. ResourceNotFoundException, ParameterNotFound or invalid JSON.
+func Classify(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var we *Error
+	if errors.As(err, &we) {
+		return we
+	}
+
+	we = &Error{Category: CategoryPermanent, Err: err}
+
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		we.Code = ae.ErrorCode()
+	}
+
+	switch {
+	case IsThrottle(err):
+		we.Category = CategoryTransient
+		we.Throttle = true
+	case slices.Contains(authCodes, we.Code):
+		we.Category = CategoryAuth
+	case IsTransient(err), errors.Is(err, context.DeadlineExceeded),
+		ae != nil && ae.ErrorFault() == smithy.FaultServer:
+		we.Category = CategoryTransient
+	}
+
+	return we
+}
@@ -0,0 +1,154 @@
+// Package watch schedules the polls of the watchers of the config providers
+package watch
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// Strategy is the polling strategy of a watcher
+type Strategy struct {
+	// Jitter randomizes each interval by up to the fraction, e.g. 0.1 for ±10%,
+	// so watchers started at the same time do not poll in lockstep
+	Jitter float64
+	// Multiplier multiplies the interval after a throttling or transient error,
+	// and divides it after each success until the interval is back to the watch interval
+	Multiplier float64
+	// MaxInterval is the maximum backed off interval
+	MaxInterval time.Duration
+	// Policy is the action on each category of errors
+	Policy Policy
+}
+
+// Action is the action of a watcher on an error
+type Action int
+
+const (
+	// ActionSlowDown backs off up to the maximum interval, the default
+	ActionSlowDown Action = iota
+	// ActionRetry keeps polling at the current interval
+	ActionRetry
+	// ActionStop stops watching
+	ActionStop
+)
+
+// Policy is the action of a watcher on each category of errors
+type Policy struct {
+	Transient Action
+	Permanent Action
+	Auth      Action
+}
+
+// DefaultPolicy returns the default policy, slowing down on every category of errors
+func DefaultPolicy() Policy {
+	return Policy{
+		Transient: ActionSlowDown,
+		Permanent: ActionSlowDown,
+		Auth:      ActionSlowDown,
+	}
+}
+
+// Action returns the action on the category of errors
+func (p Policy) Action(c Category) Action {
+	switch c {
+	case CategoryPermanent:
+		return p.Permanent
+	case CategoryAuth:
+		return p.Auth
+	default:
+		return p.Transient
+	}
+}
+
+// DefaultStrategy returns the default strategy, ±10% jitter, doubling up to 5 minutes on errors
+func DefaultStrategy() Strategy {
+	return Strategy{
+		Jitter:      0.1,
+		Multiplier:  2,
+		MaxInterval: 5 * time.Minute,
+		Policy:      DefaultPolicy(),
+	}
+}
+
+// Poller returns the delays between the polls of a watcher, not safe for concurrent use
+type Poller struct {
+	s        Strategy
+	interval time.Duration
+	current  time.Duration
+}
+
+// NewPoller returns a Poller polling every interval with the strategy
+func NewPoller(interval time.Duration, s Strategy) *Poller {
+	if s.Multiplier < 1 {
+		s.Multiplier = 1
+	}
+	if s.MaxInterval < interval {
+		s.MaxInterval = interval
+	}
+
+	return &Poller{
+		s:        s,
+		interval: interval,
+		current:  interval,
+	}
+}
+
+// First returns the delay of the first poll, a random part of the interval when jitter is enabled
+func (p *Poller) First() time.Duration {
+	if p.s.Jitter <= 0 {
+		return p.interval
+	}
+
+	//nolint:gosec // The jitter does not need a cryptographically secure random number
+	return time.Duration(rand.Int64N(int64(p.interval))) + 1
+}
+
+// Next returns the delay of the next poll after a poll returned err, backing off on the errors
+// of the policy and recovering gradually after a success, stop is set when the policy stops watching
+func (p *Poller) Next(err *Error) (d time.Duration, stop bool) {
+	if err == nil {
+		p.current = max(time.Duration(float64(p.current)/p.s.Multiplier), p.interval)
+
+		return p.jitter(p.current), false
+	}
+
+	switch p.s.Policy.Action(err.Category) {
+	case ActionStop:
+		return 0, true
+	case ActionSlowDown:
+		p.current = min(time.Duration(float64(p.current)*p.s.Multiplier), p.s.MaxInterval)
+	case ActionRetry:
+		// Keep the current interval
+	}
+
+	return p.jitter(p.current), false
+}
+
+// Interval returns the current interval without jitter
+func (p *Poller) Interval() time.Duration {
+	return p.current
+}
+
+func (p *Poller) jitter(d time.Duration) time.Duration {
+	if p.s.Jitter <= 0 {
+		return d
+	}
+
+	//nolint:gosec // The jitter does not need a cryptographically secure random number
+	f := 1 + p.s.Jitter*(2*rand.Float64()-1)
+
+	return max(time.Duration(float64(d)*f), time.Millisecond)
+}
+
+// IsThrottle reports whether the AWS API throttled the request, e.g. ThrottlingException
+func IsThrottle(err error) bool {
+	return retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary
+}
+
+// IsTransient reports whether the error is retryable, e.g. connection errors and 5xx responses
+func IsTransient(err error) bool {
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
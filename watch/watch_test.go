@@ -0,0 +1,124 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+var (
+	errThrottle  = &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+	errTransient = &smithy.GenericAPIError{Code: "RequestTimeout"}
+	errNotFound  = &smithy.GenericAPIError{Code: "ResourceNotFoundException"}
+
+	errAccessDenied = &smithy.GenericAPIError{Code: "AccessDeniedException"}
+)
+
+func TestPollerBackoff(t *testing.T) {
+	p := NewPoller(time.Second, Strategy{
+		Multiplier:  2,
+		MaxInterval: 5 * time.Second,
+		Policy:      Policy{Permanent: ActionRetry},
+	})
+
+	steps := []struct {
+		err  error
+		want time.Duration
+	}{
+		{nil, time.Second},
+		{errThrottle, 2 * time.Second},
+		{fmt.Errorf("wrapped, %w", errThrottle), 4 * time.Second},
+		{errTransient, 5 * time.Second},
+		{errThrottle, 5 * time.Second},
+		{errNotFound, 5 * time.Second},
+		{nil, 2500 * time.Millisecond},
+		{nil, 1250 * time.Millisecond},
+		{nil, time.Second},
+		{errors.New("other"), time.Second},
+		{errAccessDenied, 2 * time.Second},
+	}
+
+	for i, s := range steps {
+		d, stop := p.Next(Classify(s.err))
+		if d != s.want || stop {
+			t.Errorf("step %d: %s, %t, expected %s", i, d, stop, s.want)
+		}
+	}
+}
+
+func TestPollerStop(t *testing.T) {
+	p := NewPoller(time.Second, Strategy{Policy: Policy{Auth: ActionStop}})
+
+	if _, stop := p.Next(Classify(errNotFound)); stop {
+		t.Error("stopped on a permanent error")
+	}
+	if _, stop := p.Next(Classify(errAccessDenied)); !stop {
+		t.Error("expected to stop on an auth error")
+	}
+}
+
+func TestPollerJitter(t *testing.T) {
+	p := NewPoller(time.Second, DefaultStrategy())
+
+	for range 100 {
+		if d := p.First(); d <= 0 || d > time.Second {
+			t.Fatalf("first delay: %s, expected within the interval", d)
+		}
+
+		if d, _ := p.Next(nil); d < 900*time.Millisecond || d > 1100*time.Millisecond {
+			t.Fatalf("delay: %s, expected the interval ±10%%", d)
+		}
+	}
+}
+
+func TestIsThrottle(t *testing.T) {
+	if !IsThrottle(errThrottle) || IsThrottle(errTransient) || IsThrottle(nil) {
+		t.Error("IsThrottle")
+	}
+	if !IsTransient(errTransient) || !IsTransient(errThrottle) || IsTransient(errNotFound) {
+		t.Error("IsTransient")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err      error
+		category Category
+		target   error
+	}{
+		{errThrottle, CategoryTransient, ErrTransient},
+		{errTransient, CategoryTransient, ErrTransient},
+		{&smithy.GenericAPIError{Code: "InternalServerError", Fault: smithy.FaultServer}, CategoryTransient, ErrTransient},
+		{fmt.Errorf("GetSecretValue, %w", errNotFound), CategoryPermanent, ErrPermanent},
+		{errors.New("invalid JSON"), CategoryPermanent, ErrPermanent},
+		{fmt.Errorf("GetParameters, %w", errAccessDenied), CategoryAuth, ErrAuth},
+		{&smithy.GenericAPIError{Code: "ExpiredTokenException"}, CategoryAuth, ErrAuth},
+	}
+
+	for _, tt := range tests {
+		err := Classify(tt.err)
+		if err.Category != tt.category {
+			t.Errorf("%v: %s, expected %s", tt.err, err.Category, tt.category)
+		}
+
+		wrapped := fmt.Errorf("watch, %w", err)
+		if !errors.Is(wrapped, tt.target) || !errors.Is(wrapped, tt.err) {
+			t.Errorf("%v: errors.Is does not match %v", tt.err, tt.target)
+		}
+
+		var we *Error
+		if !errors.As(wrapped, &we) || we != err {
+			t.Errorf("%v: errors.As does not match", tt.err)
+		}
+	}
+
+	if err := Classify(errThrottle); !err.Throttle || err.Code != "ThrottlingException" {
+		t.Errorf("throttle: %+v", err)
+	}
+	if Classify(nil) != nil {
+		t.Error("Classify(nil) is not nil")
+	}
+}